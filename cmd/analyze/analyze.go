@@ -0,0 +1,156 @@
+// analyze runs the matchers of a prefetch config against a source dir without
+// downloading the items, and prints what they find. It exits with 1 if a matcher
+// fails or finds an invalid item, so it can check changes of prefetch.json in CI.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"text/tabwriter"
+
+	"internal/common"
+	"internal/prefetcher"
+)
+
+// analyzedItem is an item as printed with -json
+type analyzedItem struct {
+	Prefetcher  string `json:"prefetcher"`
+	Name        string `json:"name,omitempty"`
+	Url         string `json:"url"`
+	Hash        string `json:"hash,omitempty"`
+	HashType    string `json:"hash_type,omitempty"`
+	Source      string `json:"source,omitempty"`
+	CacheStatus string `json:"cache_status"`
+	Error       string `json:"error,omitempty"`
+}
+
+type failedPrefetcher struct {
+	Name  string `json:"name"`
+	Error string `json:"error"`
+}
+
+type report struct {
+	Items  []analyzedItem     `json:"items"`
+	Failed []failedPrefetcher `json:"failed"`
+}
+
+func main() {
+	jsonOutput := flag.Bool("json", false, "print the items as JSON instead of a table")
+	cacheDir := flag.String("cache_dir", defaultBazelCacheDir(), "bazel repository cache to check the items against")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [-json] [-cache_dir DIR] <PREFETCH.JSON> <SRC_DIR>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	prefetchConfigFile := flag.Arg(0)
+	srcDir := flag.Arg(1)
+
+	l := common.NewLoggerWithPrefixAndColor("Main: ")
+
+	prefetchConfig, err := common.ReadPrefetchConfigJson(prefetchConfigFile)
+	if err != nil {
+		l.Fatalf("failed to load prefetch config: %v", err)
+	}
+
+	prefetchers, err := prefetcher.CreatePrefetchersFromConfig(srcDir, prefetchConfig)
+	if err != nil {
+		l.Fatalf("failed to generate prefetchers: %v", err)
+	}
+
+	analysis := prefetcher.AnalyzeAll(prefetchers, *cacheDir)
+	r := newReport(analysis)
+
+	if *jsonOutput {
+		err = printJson(r)
+	} else {
+		err = printTable(r)
+	}
+	if err != nil {
+		l.Fatalf("failed to print the items: %v", err)
+	}
+
+	if analysis.HasErrors() {
+		l.Printf("%d prefetchers failed, %d items found.", len(r.Failed), len(r.Items))
+		os.Exit(1)
+	}
+	l.Printf("%d items found.", len(r.Items))
+}
+
+func newReport(analysis *prefetcher.Analysis) *report {
+	r := &report{
+		Items:  make([]analyzedItem, 0, len(analysis.Items)),
+		Failed: make([]failedPrefetcher, 0, len(analysis.Failed)),
+	}
+	for _, item := range analysis.Items {
+		printed := analyzedItem{
+			Prefetcher:  item.Prefetcher,
+			Name:        item.Name,
+			Url:         item.Url,
+			Hash:        item.Hash,
+			HashType:    item.HashType,
+			Source:      item.Source,
+			CacheStatus: string(item.CacheStatus),
+		}
+		if item.Error != nil {
+			printed.Error = item.Error.Error()
+		}
+		r.Items = append(r.Items, printed)
+	}
+	for _, failed := range analysis.Failed {
+		r.Failed = append(r.Failed, failedPrefetcher{Name: failed.Name, Error: failed.Err.Error()})
+	}
+	return r
+}
+
+func printJson(r *report) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+func printTable(r *report) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PREFETCHER\tURL\tHASH\tSOURCE\tCACHE")
+	for _, item := range r.Items {
+		hash := "-"
+		if item.Hash != "" {
+			hash = item.HashType + ":" + item.Hash
+		}
+		cache := item.CacheStatus
+		if item.Error != "" {
+			cache = "error: " + item.Error
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", item.Prefetcher, item.Url, hash, valueOr(item.Source, "-"), cache)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if len(r.Failed) > 0 {
+		fmt.Println()
+		fmt.Println("FAILED:")
+		for _, failed := range r.Failed {
+			fmt.Printf("  %s: %s\n", failed.Name, failed.Error)
+		}
+	}
+	return nil
+}
+
+func valueOr(s string, fallback string) string {
+	if s == "" {
+		return fallback
+	}
+	return s
+}
+
+func defaultBazelCacheDir() string {
+	username := os.Getenv("USER")
+	return path.Join(os.Getenv("HOME"), ".cache/bazel", fmt.Sprintf("_bazel_%s", username), "cache/repos/v1")
+}
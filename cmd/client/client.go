@@ -1,7 +1,6 @@
 package main
 
 import (
-	"crypto/sha256"
 	"fmt"
 	"io"
 	"log"
@@ -62,7 +61,9 @@ func main() {
 }
 
 func handlePrefetchItem(item *prefetcher.PrefetchItem, srcDir string, serverAddr string) error {
-	downloadURL := fmt.Sprintf("%s/files/%s/file", serverAddr, item.Hash)
+	// the server serves its repository cache under /files
+	checksum := item.Checksum()
+	downloadURL := fmt.Sprintf("%s/files/content_addressable/%s/%s/file", serverAddr, checksum.Algorithm, checksum.Hex)
 	err := downloadFile(downloadURL, item)
 	if err != nil {
 		log.Printf("Error: failed to download file, %v", err)
@@ -154,14 +155,13 @@ func downloadFile(url string, item *prefetcher.PrefetchItem) error {
 
 	// Update the item's Path to the temporary file path
 	item.Path = tempFile.Name()
-	item.HashOfUrl = fmt.Sprintf("%x", sha256.Sum256([]byte(item.Url)))
 	log.Printf("File downloaded successfully to: %s", item.Path)
 
 	return nil
 }
 
 func compareFileHash(item *prefetcher.PrefetchItem) (bool, error) {
-	hash, err := common.HashOfFile(item.Path)
+	hash, err := common.HashOfFileWithAlgorithm(item.Path, item.Checksum().Algorithm)
 	if err != nil {
 		log.Printf("Failed to calculate file path: %v", err)
 		err = fmt.Errorf("failed to calculate file path: %w", err)
@@ -173,10 +173,15 @@ func compareFileHash(item *prefetcher.PrefetchItem) (bool, error) {
 
 func putFileIntoBazelCache(item *prefetcher.PrefetchItem, cacheDir string) error {
 	log.Printf("Placing to bazel cache")
-	cacheDirInside := path.Join(cacheDir, "content_addressable", "sha256")
-	outerDir := path.Join(cacheDirInside, item.Hash)
+	outerDir := item.Checksum().CacheDir(cacheDir)
 	innerFile := path.Join(outerDir, "file")
-	hashFilePath := path.Join(outerDir, fmt.Sprintf("id-%s", item.HashOfUrl))
+	// bazel only uses the entry if it was downloaded for the canonical id of the rule
+	idFile, err := item.IdFile()
+	if err != nil {
+		item.Error = err
+		return err
+	}
+	hashFilePath := path.Join(outerDir, idFile)
 	os.MkdirAll(outerDir, 0755)
 
 	hashFile, err := os.Create(hashFilePath)
@@ -17,3 +17,10 @@ replace internal/prefetcher => ../../internal/prefetcher
 require internal/common v1.0.0
 
 require internal/prefetcher v1.0.0
+
+require (
+	github.com/BurntSushi/toml v1.4.0 // indirect
+	github.com/bmatcuk/doublestar/v4 v4.6.1 // indirect
+	go.starlark.net v0.0.0-20231121155337-90ade8b19d09 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
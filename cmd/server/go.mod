@@ -26,4 +26,10 @@ require internal/httpserver v1.0.0
 
 require internal/prefetcher v1.0.0
 
-require github.com/mattn/go-sqlite3 v1.14.27 // indirect
+require (
+	github.com/BurntSushi/toml v1.4.0 // indirect
+	github.com/bmatcuk/doublestar/v4 v4.6.1 // indirect
+	github.com/mattn/go-sqlite3 v1.14.27 // indirect
+	go.starlark.net v0.0.0-20231121155337-90ade8b19d09 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
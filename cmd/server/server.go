@@ -2,12 +2,14 @@ package main
 
 import (
 	"crypto/rand"
-	"crypto/sha256"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"path"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"internal/common"
@@ -21,7 +23,13 @@ import (
 type server struct {
 	ServerConfig *common.ServerConfig
 	ItemTable    *db.ItemTable
+	MirrorTable  *db.MirrorTable
+	CacheIndex   *db.CacheIndexTable
 	Prefetchers  []prefetcher.PrefetchMatchers
+	HostLimiter  *common.HostLimiter
+
+	// items are processed in parallel, and write the tables one at a time
+	DbMtx sync.Mutex
 }
 
 func main() {
@@ -42,9 +50,18 @@ func main() {
 	serverConfig.Server.Workdir = strings.ReplaceAll(serverConfig.Server.Workdir, "$home", os.Getenv("HOME"))
 	serverConfig.SrcDir = path.Join(serverConfig.Server.Workdir, "src")
 	server.ServerConfig = serverConfig
-
-	// create prefetch matchers
-	prefetchers, err := prefetcher.CreatePrefetchersFromConfig(serverConfig.SrcDir, serverConfig.PrefetchConfig)
+	concurrency := serverConfig.Server.Concurrency
+	server.HostLimiter = common.NewHostLimiter(concurrency.Hosts, concurrency.DefaultHostLimit)
+
+	// create prefetch matchers, which download remote files like checksum files with the configured downloader
+	var matcherDownloader prefetcher.Downloader
+	if downloader, err := downloaders.CreateDownloaderFactory(serverConfig).Create(serverConfig.Server.Downloader); err == nil {
+		matcherDownloader = downloader
+	} else {
+		log.Printf("Cannot create downloader %s for matchers, using HTTP, err = %s", serverConfig.Server.Downloader, err)
+	}
+	matcherDownloader = prefetcher.NewHostLimitedDownloader(matcherDownloader, server.HostLimiter)
+	prefetchers, err := prefetcher.CreatePrefetchersFromConfigWithDownloader(serverConfig.SrcDir, serverConfig.PrefetchConfig, matcherDownloader)
 	if err != nil {
 		log.Fatalf("Failed to generate prefetchers: %v", err)
 	}
@@ -70,15 +87,38 @@ func main() {
 	log.Printf("Item table created successfully: %v", itemTable)
 	server.ItemTable = itemTable
 
+	mirrorTable := db.NewMirrorTable(database)
+	err = mirrorTable.Create()
+	if err != nil {
+		log.Printf("Error creating mirror table: %s", err)
+		return
+	}
+	server.MirrorTable = mirrorTable
+
+	// index of the files in the cache, so analysis doesn't walk it; files added
+	// while the server was not running are picked up by a scan
+	cacheIndex := db.NewCacheIndexTable(database)
+	err = cacheIndex.Create()
+	if err != nil {
+		log.Printf("Error creating cache index table: %s", err)
+		return
+	}
+	err = cacheIndex.Scan(path.Join(serverConfig.Server.Workdir, "data"))
+	if err != nil {
+		log.Printf("Error scanning cache: %s", err)
+		return
+	}
+	server.CacheIndex = cacheIndex
+
 	// LOGO
 	log.Print(common.Imafish())
 
 	// start http server
 	httpServerBuilder := httpserver.NewHttpServerBuilder(serverConfig)
 	httpServerBuilder.ServeFiles()
-	httpServerBuilder.ServeApiV1Files()
+	httpServerBuilder.ServeApiV1Files(server.CacheIndex)
 	httpServer := httpServerBuilder.Build()
-	log.Printf("Starting HTTP server on port %d", serverConfig.Server.Port)
+	log.Printf("Starting HTTP server on %s", httpServer.Addr)
 	go httpServer.ListenAndServe()
 
 	// start scheduler (periodically update repository, parse files and download)
@@ -110,8 +150,13 @@ func process(server *server) {
 
 	updateGit(config)
 
+	// files can be removed from the cache while the server runs, e.g. by a cleanup
+	if err := server.CacheIndex.Scan(path.Join(config.Server.Workdir, "data")); err != nil {
+		log.Printf("Error scanning cache: %s", err)
+	}
+
 	common.LogSeparator("Analyzing prefetch items...")
-	items, err := prefetcher.AnalyzePrefetchItems(prefetchers, path.Join(config.Server.Workdir, "data"))
+	items, err := prefetcher.AnalyzePrefetchItemsWithIndex(prefetchers, server.CacheIndex, config.Server.Concurrency.Workers)
 	if err != nil {
 		log.Println("Error analyzing prefetch items:", err)
 		return
@@ -124,8 +169,10 @@ func process(server *server) {
 	end := time.Now()
 	common.LogSeparator("debug print item table")
 	server.ItemTable.DebugPrintAll()
+	common.LogSeparator("debug print mirror table")
+	server.MirrorTable.DebugPrintAll()
 	common.LogSeparator("summary")
-	log.Printf("Total items: %d, Successful: %d, Time taken: %s", len(items), successful, end.Sub(start))
+	log.Printf("Total items: %d, Successful: %d, Failed: %d, Time taken: %s", len(items), successful, len(items)-successful, end.Sub(start))
 }
 
 func processPrefetchItems(server *server, items []*prefetcher.PrefetchItem) int {
@@ -136,12 +183,24 @@ func processPrefetchItems(server *server, items []*prefetcher.PrefetchItem) int
 		return 0
 	}
 
+	// the logs of every item are printed in the order of the items, whatever order they finish in
+	logs := common.NewOrderedLogs(len(items))
+	errs := make([]error, len(items))
+	common.RunParallel(len(items), config.Server.Concurrency.Workers, func(i int) {
+		defer logs.Done(i)
+		l := logs.Logger(i)
+		item := items[i]
+		common.LogSeparatorTo(l, item.Url)
+		errs[i] = processOneItem(server, l, item, downloadDir)
+		if errs[i] != nil {
+			l.Printf("Error: failed to process item %s, err: %v", item.Url, errs[i])
+		}
+	})
+
 	successful := 0
-	for _, item := range items {
-		common.LogSeparator(item.Url)
-		err := processOneItem(server, item, downloadDir)
+	for i, err := range errs {
 		if err != nil {
-			log.Printf("Error: failed to process item %s, err: %v", item.Url, err)
+			log.Printf("Failed: %s", items[i].Url)
 		} else {
 			successful += 1
 		}
@@ -149,47 +208,67 @@ func processPrefetchItems(server *server, items []*prefetcher.PrefetchItem) int
 	return successful
 }
 
-func processOneItem(server *server, item *prefetcher.PrefetchItem, downloadDir string) error {
+// processOneItem downloads an item to the cache. Items are processed in parallel,
+// so it logs to l, and writes the tables holding server.DbMtx.
+func processOneItem(server *server, l *log.Logger, item *prefetcher.PrefetchItem, downloadDir string) error {
 	config := server.ServerConfig
 	randStr := make([]byte, 8)
 	rand.Read(randStr)
 	filePath := path.Join(downloadDir, fmt.Sprintf("%x", randStr))
 	if common.FileExists(filePath) {
-		log.Printf("File already downloaded, deleting: %s", filePath)
+		l.Printf("File already downloaded, deleting: %s", filePath)
 		os.Remove(filePath)
 	}
-	defer os.Remove(filePath)
-
-	log.Printf("Downloading file from URL: %s", item.Url)
-	err := downloadFile(config, item.Url, filePath)
-	if err != nil {
-		log.Printf("Failed to download file from %s: %v", item.Url, err)
-		item.Error = err
-		return err
+	defer downloaders.RemovePartial(filePath)
+
+	// try the mirrors in turn, until one serves the right content
+	mirrors := mirrorsToTry(server, l, item)
+	var errs []error
+	for i, url := range mirrors {
+		l.Printf("Downloading file from mirror %d/%d: %s, found at: %s", i+1, len(mirrors), url, item.Source)
+		err := downloadFromMirror(server, l, item, url, filePath)
+		recordMirror(server, l, item, url, err)
+		if err == nil {
+			item.ServedBy = url
+			break
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", url, err))
 	}
-	log.Printf("File downloaded successfully: %s", filePath)
-
-	// calculate hashes, and update Item object
-	err = updateItem(config, item, filePath)
-	if err != nil {
-		log.Printf("Failed to update item, error is: %v", err)
-		err = fmt.Errorf("failed to update item, error is: %w", err)
+	if item.ServedBy == "" {
+		err := fmt.Errorf("all %d mirrors of %s failed: %w", len(mirrors), item.Url, errors.Join(errs...))
+		l.Print(err.Error())
 		item.Error = err
 		return err
 	}
 
 	cacheDir := path.Join(config.Server.Workdir, "data")
-	err = saveAsBazelCache(item, cacheDir)
+	err := saveAsBazelCache(l, item, cacheDir)
 	if err != nil {
-		log.Printf("Failed to move file to bazel cache: %v", err)
+		l.Printf("Failed to move file to bazel cache: %v", err)
 		item.Error = fmt.Errorf("failed to move file to bazel cache, error is: %w", err)
 		return err
 	}
 
+	server.DbMtx.Lock()
+	defer server.DbMtx.Unlock()
+
+	checksum := item.Checksum()
+	err = server.CacheIndex.Add(&db.CacheIndexEntry{
+		Algorithm:   checksum.Algorithm,
+		Hash:        checksum.Hex,
+		IdHash:      item.HashOfCanonicalId,
+		CanonicalId: item.BazelCanonicalId(),
+		Size:        item.Size,
+	})
+	if err != nil {
+		// the next scan at startup adds it
+		l.Printf("Failed to add item to cache index: %v", err)
+	}
+
 	// save to database
-	err = saveItemToDatabase(server.ItemTable, item)
+	err = saveItemToDatabase(l, server.ItemTable, item)
 	if err != nil {
-		log.Printf("Failed to save item to database: %v", err)
+		l.Printf("Failed to save item to database: %v", err)
 		item.Error = fmt.Errorf("failed to save item to database, error is: %w", err)
 		return err
 	}
@@ -197,44 +276,110 @@ func processOneItem(server *server, item *prefetcher.PrefetchItem, downloadDir s
 	return nil
 }
 
-func downloadFile(config *common.ServerConfig, url, filePath string) error {
-	log.Printf("Downloading file from URL: %s to %s", url, filePath)
-	downloaderFactory := downloaders.CreateDownloaderFactory(config)
+// downloadFromMirror downloads an item from one of its mirrors, and verifies its hash.
+func downloadFromMirror(server *server, l *log.Logger, item *prefetcher.PrefetchItem, url string, filePath string) error {
+	config := server.ServerConfig
+	// a failed mirror may have left a partial file, or part files of it
+	downloaders.RemovePartial(filePath)
+
+	err := downloadFile(server, l, url, filePath)
+	if err != nil {
+		l.Printf("Failed to download file from %s: %v", url, err)
+		return err
+	}
+	l.Printf("File downloaded successfully: %s", filePath)
+
+	// calculate hashes, and update Item object
+	err = updateItem(l, config, item, filePath)
+	if err != nil {
+		l.Printf("Failed to update item, error is: %v", err)
+		return fmt.Errorf("failed to update item, error is: %w", err)
+	}
+	return nil
+}
+
+// mirrorsToTry returns the mirrors of an item, those which were broken last time last.
+func mirrorsToTry(server *server, l *log.Logger, item *prefetcher.PrefetchItem) []string {
+	mirrors := item.Mirrors()
+	server.DbMtx.Lock()
+	known, err := server.MirrorTable.GetByItemUrl(item.Url)
+	server.DbMtx.Unlock()
+	if err != nil {
+		l.Printf("Failed to get mirrors of %s from database: %v", item.Url, err)
+		return mirrors
+	}
+
+	broken := make(map[string]bool)
+	for _, mirror := range known {
+		if mirror.Broken {
+			broken[mirror.Url] = true
+		}
+	}
+	sort.SliceStable(mirrors, func(i, j int) bool {
+		return !broken[mirrors[i]] && broken[mirrors[j]]
+	})
+	return mirrors
+}
+
+// recordMirror saves whether a mirror of an item served the right content.
+func recordMirror(server *server, l *log.Logger, item *prefetcher.PrefetchItem, url string, downloadErr error) {
+	mirror := &db.Mirror{Url: url, ItemUrl: item.Url, Broken: downloadErr != nil}
+	if downloadErr != nil {
+		mirror.Error = downloadErr.Error()
+	}
+	server.DbMtx.Lock()
+	defer server.DbMtx.Unlock()
+	if err := server.MirrorTable.CreateOrUpdate(mirror); err != nil {
+		l.Printf("Failed to save mirror %s to database: %v", url, err)
+	}
+}
+
+func downloadFile(server *server, l *log.Logger, url, filePath string) error {
+	config := server.ServerConfig
+	l.Printf("Downloading file from URL: %s to %s", url, filePath)
+	// downloaders take the slots of the host themselves, one for every connection
+	downloaderFactory := downloaders.CreateItemDownloaderFactory(config, l, server.HostLimiter)
 	downloader, err := downloaderFactory.Create(config.Server.Downloader)
 	if err != nil {
-		log.Printf("Cannot create downloader %s, err = %s", config.Server.Downloader, err)
+		l.Printf("Cannot create downloader %s, err = %s", config.Server.Downloader, err)
 		return err
 	}
 	err = downloader.Download(url, filePath)
 	if err != nil {
-		log.Printf("Failed to download file from %s.", url)
+		l.Printf("Failed to download file from %s.", url)
 		return err
 	}
 
 	return nil
 }
 
-func updateItem(_ *common.ServerConfig, item *prefetcher.PrefetchItem, filePath string) error {
-	log.Printf("update item information.")
+func updateItem(l *log.Logger, _ *common.ServerConfig, item *prefetcher.PrefetchItem, filePath string) error {
+	l.Printf("update item information.")
 
 	item.Path = filePath
 
-	// Hash of URL
-	item.HashOfUrl = fmt.Sprintf("%x", sha256.Sum256([]byte(item.Url)))
+	// Hash of the canonical id, which bazel looks the item up by
+	hashOfCanonicalId, err := item.Checksum().IdHash(item.BazelCanonicalId())
+	if err != nil {
+		err = fmt.Errorf("failed to hash canonical id of %s: %w", item.Url, err)
+		l.Print(err.Error())
+		return err
+	}
+	item.HashOfCanonicalId = hashOfCanonicalId
 
-	// compare Hash of File
-	hash, err := common.HashOfFile(filePath)
+	// compare Hash of File, with the algorithm of the expected hash
+	hash, err := common.HashOfFileWithAlgorithm(filePath, item.Checksum().Algorithm)
 	if err != nil {
-		log.Printf("Failed to calculate file path: %v", err)
+		l.Printf("Failed to calculate file path: %v", err)
 		err = fmt.Errorf("failed to calculate file path: %w", err)
 		return err
 	}
 	if item.Hash == "" {
-		log.Printf("file %s does not have a pre-defined hash. updating it to %s", item.Path, hash)
+		l.Printf("file %s does not have a pre-defined hash. updating it to %s", item.Path, hash)
 		item.Hash = hash
 	} else if hash != item.Hash {
-		err = fmt.Errorf("file `%s` hash does not match. Expected: %s, Actual: %s", filePath, item.Hash, hash)
-		log.Print(err.Error())
+		err = &downloaders.HashMismatchError{Path: filePath, Algorithm: item.Checksum().Algorithm, Expected: item.Hash, Actual: hash}
+		l.Print(err.Error())
 		return err
 	}
 
@@ -242,7 +387,7 @@ func updateItem(_ *common.ServerConfig, item *prefetcher.PrefetchItem, filePath
 	fileInfo, err := os.Stat(filePath)
 	if err != nil {
 		err = fmt.Errorf("failed to get file size: %w", err)
-		log.Print(err.Error())
+		l.Print(err.Error())
 		return err
 	}
 	item.Size = fileInfo.Size()
@@ -250,40 +395,44 @@ func updateItem(_ *common.ServerConfig, item *prefetcher.PrefetchItem, filePath
 	return nil
 }
 
-func saveItemToDatabase(itemTable *db.ItemTable, item *prefetcher.PrefetchItem) error {
-	log.Printf("Saving item to database: %+v", item)
+func saveItemToDatabase(l *log.Logger, itemTable *db.ItemTable, item *prefetcher.PrefetchItem) error {
+	l.Printf("Saving item to database: %+v", item)
 
 	newItem := &db.Item{
-		Hash:    item.Hash,
-		Url:     item.Url,
-		UrlHash: item.HashOfUrl,
-		Path:    item.Path,
-		Size:    item.Size,
+		Hash:            item.Hash,
+		Url:             item.Url,
+		Urls:            strings.Join(item.Urls, " "),
+		CanonicalId:     item.BazelCanonicalId(),
+		CanonicalIdHash: item.HashOfCanonicalId,
+		Source:          item.Source,
+		ServedBy:        item.ServedBy,
+		Path:            item.Path,
+		Size:            item.Size,
 	}
 
 	// Insert the item into the database
 	err := itemTable.CreateOrUpdate(newItem)
 	if err != nil {
 		err = fmt.Errorf("failed to insert/update item into database: %v", err)
-		log.Print(err)
+		l.Print(err)
 		return err
 	}
 
-	log.Printf("Item saved to database successfully: %+v", newItem)
+	l.Printf("Item saved to database successfully: %+v", newItem)
 	return nil
 }
 
-func saveAsBazelCache(item *prefetcher.PrefetchItem, cacheDir string) error {
-	log.Printf("Placing to bazel cache")
-	cacheDirInside := path.Join(cacheDir, "content_addressable", "sha256")
-	outerDir := path.Join(cacheDirInside, item.Hash)
+func saveAsBazelCache(l *log.Logger, item *prefetcher.PrefetchItem, cacheDir string) error {
+	l.Printf("Placing to bazel cache")
+	outerDir := item.Checksum().CacheDir(cacheDir)
 	innerFile := path.Join(outerDir, "file")
-	hashFilePath := path.Join(outerDir, fmt.Sprintf("id-%s", item.HashOfUrl))
+	// an entry may hold files of several items, with an id file for each of them
+	hashFilePath := path.Join(outerDir, fmt.Sprintf("id-%s", item.HashOfCanonicalId))
 	os.MkdirAll(outerDir, 0755)
 
 	hashFile, err := os.Create(hashFilePath)
 	if err != nil {
-		log.Printf("Failed to create file %s", hashFilePath)
+		l.Printf("Failed to create file %s", hashFilePath)
 		item.Error = fmt.Errorf("failed to create file %s, error is: %v", hashFilePath, err)
 		return err
 	}
@@ -292,12 +441,12 @@ func saveAsBazelCache(item *prefetcher.PrefetchItem, cacheDir string) error {
 	err = os.Rename(item.Path, innerFile)
 	if err != nil {
 		err = fmt.Errorf("failed to move from %s to %s, error: %s", item.Path, innerFile, err)
-		log.Print(err.Error())
+		l.Print(err.Error())
 		item.Error = err
 		return err
 	}
 
-	log.Printf("File moved to bazel cache: %s", innerFile)
+	l.Printf("File moved to bazel cache: %s", innerFile)
 	item.Path = outerDir
 	return nil
 }
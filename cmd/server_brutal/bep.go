@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"internal/common"
+	"internal/db"
+)
+
+// buildEvent is the part of a build event protocol event (written by
+// `--build_event_json_file`) we are interested in: `fetch` events.
+type buildEvent struct {
+	Id struct {
+		Fetch *struct {
+			Url        string `json:"url"`
+			Downloader string `json:"downloader"`
+		} `json:"fetch"`
+	} `json:"id"`
+	Fetch *struct {
+		Success bool `json:"success"`
+	} `json:"fetch"`
+}
+
+// readFetchEvents returns the fetch events of a JSON build event protocol file.
+func readFetchEvents(bepFile string, command string) ([]*db.FetchEvent, error) {
+	file, err := os.Open(bepFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var events []*db.FetchEvent
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var event buildEvent
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			return events, fmt.Errorf("failed to parse build event: %w", err)
+		}
+		if event.Id.Fetch == nil {
+			continue
+		}
+
+		events = append(events, &db.FetchEvent{
+			Command:    command,
+			Url:        event.Id.Fetch.Url,
+			UrlHash:    fmt.Sprintf("%x", sha256.Sum256([]byte(event.Id.Fetch.Url))),
+			Downloader: event.Id.Fetch.Downloader,
+			Success:    event.Fetch != nil && event.Fetch.Success,
+		})
+	}
+
+	return events, scanner.Err()
+}
+
+// saveFetchEvents stores the fetch events of a bazel command, and logs the failed ones.
+func saveFetchEvents(table *db.FetchEventTable, bepFile string, command string) error {
+	l := common.NewLoggerWithPrefixAndColor("bep: ")
+
+	events, err := readFetchEvents(bepFile, command)
+	if err != nil {
+		l.Printf("Failed to read build events from %s: %v", bepFile, err)
+		// still save what was read before the error
+	}
+
+	failed := 0
+	for _, event := range events {
+		if !event.Success {
+			failed++
+			l.Printf("Failed to fetch: %s", event.Url)
+		}
+		if err := table.CreateOrUpdate(event); err != nil {
+			l.Printf("Failed to save fetch event of %s: %v", event.Url, err)
+			return err
+		}
+	}
+
+	l.Printf("Command `%s` fetched %d URLs, %d failed", command, len(events), failed)
+	return err
+}
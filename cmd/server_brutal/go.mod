@@ -23,3 +23,7 @@ require internal/common v1.0.0
 require internal/httpserver v1.0.0
 
 require internal/cleanup v1.0.0
+
+require internal/db v1.0.0
+
+require github.com/mattn/go-sqlite3 v1.14.27 // indirect
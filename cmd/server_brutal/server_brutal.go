@@ -13,13 +13,16 @@ import (
 
 	"internal/cleanup"
 	"internal/common"
+	"internal/db"
 	"internal/git"
 	"internal/httpserver"
 )
 
 type server struct {
-	ServerConfig  *common.ServerConfig
-	BazelCommands *common.BazelCommandsConfig
+	ServerConfig    *common.ServerConfig
+	BazelCommands   *common.BazelCommandsConfig
+	FetchEventTable *db.FetchEventTable
+	CacheIndex      *db.CacheIndexTable
 
 	Mtx sync.Mutex
 }
@@ -52,6 +55,32 @@ func main() {
 		return
 	}
 
+	// load database
+	database, err := db.CreateAndLoadDatabase(path.Join(server.ServerConfig.Server.Workdir, "prefetch.db"))
+	if err != nil {
+		log.Printf("Error loading database: %s", err)
+		return
+	}
+	defer database.Close()
+
+	fetchEventTable := db.NewFetchEventTable(database)
+	if err := fetchEventTable.Create(); err != nil {
+		log.Printf("Error creating fetch event table: %s", err)
+		return
+	}
+	server.FetchEventTable = fetchEventTable
+
+	cacheIndex := db.NewCacheIndexTable(database)
+	if err := cacheIndex.Create(); err != nil {
+		log.Printf("Error creating cache index table: %s", err)
+		return
+	}
+	if err := cacheIndex.Scan(path.Join(server.ServerConfig.Server.Workdir, "data")); err != nil {
+		log.Printf("Error scanning cache: %s", err)
+		return
+	}
+	server.CacheIndex = cacheIndex
+
 	common.LogSeparator("server config")
 	common.PrintStruct(server, func(s string) {
 		log.Printf("%s", s)
@@ -65,9 +94,9 @@ func main() {
 	// start http server
 	httpServerBuilder := httpserver.NewHttpServerBuilder(serverConfig)
 	httpServerBuilder.ServeFiles()
-	httpServerBuilder.ServeApiV1Files()
+	httpServerBuilder.ServeApiV1Files(server.CacheIndex)
 	httpServer := httpServerBuilder.Build()
-	log.Printf("Starting HTTP server on port %d", serverConfig.Server.Port)
+	log.Printf("Starting HTTP server on %s", httpServer.Addr)
 	go httpServer.ListenAndServe()
 
 	// start scheduler (periodically update repository, parse files and download)
@@ -91,17 +120,14 @@ func process(server *server) {
 	common.LogSeparator("running bazel build --nobuild...")
 	runBazelBuild(server)
 
+	// bazel wrote to the cache behind our back
+	if err := server.CacheIndex.Scan(path.Join(config.Server.Workdir, "data")); err != nil {
+		log.Printf("Error scanning cache: %s", err)
+	}
+
 	common.LogSeparator("cleaning up...")
 	if config.Server.Cleanup.Enabled {
-		cleanup := cleanup.Cleanup{
-			Workdir:      path.Join(config.Server.Workdir, "data", "content_addressable", "sha256"),
-			MaxSize:      config.Server.Cleanup.MaxSize,
-			TolerantSize: config.Server.Cleanup.TolerantSize,
-			MaxAge:       int64(config.Server.Cleanup.MaxAge * 24 * 60 * 60), // Convert days to seconds
-		}
-		if err := cleanup.Run(); err != nil {
-			log.Printf("Error during cleanup: %s", err)
-		}
+		cleanupCache(server)
 	} else {
 		log.Printf("Cleanup is disabled in the configuration.")
 	}
@@ -111,6 +137,43 @@ func process(server *server) {
 	log.Printf("Time taken: %s", end.Sub(start))
 }
 
+// cleanupCache cleans up the directories of all hash algorithms of the cache with
+// one budget, and removes what it removed from the index.
+func cleanupCache(server *server) {
+	config := server.ServerConfig
+	root := path.Join(config.Server.Workdir, "data", "content_addressable")
+	algorithms, err := os.ReadDir(root)
+	if err != nil {
+		log.Printf("Error reading cache directory %s: %s", root, err)
+		return
+	}
+	var workdirs []string
+	for _, algorithm := range algorithms {
+		if algorithm.IsDir() {
+			workdirs = append(workdirs, path.Join(root, algorithm.Name()))
+		}
+	}
+	if len(workdirs) == 0 {
+		return
+	}
+
+	cleanup := cleanup.Cleanup{
+		Workdirs:     workdirs,
+		MaxSize:      config.Server.Cleanup.MaxSize,
+		TolerantSize: config.Server.Cleanup.TolerantSize,
+		MaxAge:       int64(config.Server.Cleanup.MaxAge * 24 * 60 * 60), // Convert days to seconds
+		Removed: func(removed string) {
+			// removed is content_addressable/<algorithm>/<hash>
+			if err := server.CacheIndex.Remove(path.Base(path.Dir(removed)), path.Base(removed)); err != nil {
+				log.Printf("Error removing %s from cache index: %s", removed, err)
+			}
+		},
+	}
+	if err := cleanup.Run(); err != nil {
+		log.Printf("Error during cleanup: %s", err)
+	}
+}
+
 func cleanGit(config *common.ServerConfig) error {
 	l := common.NewLoggerWithPrefixAndColor("git: ")
 	// Example function to update git repository
@@ -171,6 +234,11 @@ func runBazelBuild(server *server) error {
 
 	srcDir := path.Join(config.Server.Workdir, "src")
 	dataDir := path.Join(config.Server.Workdir, "data")
+	bepDir := path.Join(config.Server.Workdir, "bep")
+	if err := os.MkdirAll(bepDir, 0755); err != nil {
+		l.Printf("Failed to create directory %s: %v", bepDir, err)
+		return err
+	}
 
 	repositoryCacheParam := fmt.Sprintf("--repository_cache=%s", dataDir)
 	l.Printf("Using repository cache path: %s", dataDir)
@@ -186,12 +254,22 @@ func runBazelBuild(server *server) error {
 
 	var err error
 
-	for _, bc := range bazelCommands {
-		bc = append(bc, repositoryCacheParam)
+	for index, bc := range bazelCommands {
+		command := strings.Join(bc, " ")
+		bepFile := path.Join(bepDir, fmt.Sprintf("%d.json", index))
+		bc = append(bc, repositoryCacheParam, fmt.Sprintf("--build_event_json_file=%s", bepFile))
 		retryCnt := 5
 		for i := range retryCnt {
 			l.Printf("Attempt #%d to run bazel command...", i+1)
-			if err = runOneCommand("bazel", bc, srcDir); err != nil {
+			os.Remove(bepFile)
+			err = runOneCommand("bazel", bc, srcDir)
+
+			// failed runs have fetch events too, and they tell what failed
+			if server.FetchEventTable != nil && common.FileExists(bepFile) {
+				saveFetchEvents(server.FetchEventTable, bepFile, command)
+			}
+
+			if err != nil {
 				l.Printf("Failed to run bazel command: %v", err)
 				l.Printf("Retrying in 5 seconds...")
 				time.Sleep(5 * time.Second)
@@ -10,10 +10,15 @@ import (
 )
 
 type Cleanup struct {
-	Workdir      string
+	Workdir string
+	// Workdirs are cleaned up instead of Workdir if set, with one budget of
+	// MaxSize and TolerantSize for the entries of all of them
+	Workdirs     []string
 	MaxSize      int64
 	TolerantSize int64
 	MaxAge       int64
+	// Removed is called with the path of every removed file or directory, if set
+	Removed func(path string)
 
 	currentSize int64
 	dirInfo     []fileInfo
@@ -30,18 +35,27 @@ func (c *Cleanup) Run() error {
 	// Check if the workdir exists
 	l := common.NewLoggerWithPrefixAndColor("cleanup: ")
 
-	if _, err := os.Stat(c.Workdir); os.IsNotExist(err) {
-		return fmt.Errorf("workdir does not exist: %s", c.Workdir)
+	workdirs := c.Workdirs
+	if len(workdirs) == 0 {
+		workdirs = []string{c.Workdir}
 	}
 
-	// Get the current size of the workdir
-	l.Printf("Calculating current size of workdir: %s", c.Workdir)
-	var err error
-	c.currentSize, c.dirInfo, err = getDirInfo(c.Workdir)
-	if err != nil {
-		return fmt.Errorf("failed to get directory size: %w", err)
+	c.currentSize, c.dirInfo = 0, nil
+	for _, workdir := range workdirs {
+		if _, err := os.Stat(workdir); os.IsNotExist(err) {
+			return fmt.Errorf("workdir does not exist: %s", workdir)
+		}
+
+		// Get the current size of the workdir
+		l.Printf("Calculating current size of workdir: %s", workdir)
+		size, dirInfo, err := getDirInfo(workdir)
+		if err != nil {
+			return fmt.Errorf("failed to get directory size: %w", err)
+		}
+		c.currentSize += size
+		c.dirInfo = append(c.dirInfo, dirInfo...)
 	}
-	l.Printf("Current size of workdir: %s, items: %d", common.PrettyPrintSize(c.currentSize), len(c.dirInfo))
+	l.Printf("Current size of workdirs: %s, items: %d", common.PrettyPrintSize(c.currentSize), len(c.dirInfo))
 
 	for _, file := range c.dirInfo {
 		l.Printf("File: %s, Size: %s, ModTime: %s", file.Path, common.PrettyPrintSize(file.Size), time.Unix(file.ModTime, 0).Format(time.RFC3339))
@@ -120,6 +134,9 @@ func (c *Cleanup) doCleanUp() error {
 			deleted++
 			sizeFreed += file.Size
 			l.Printf("Removed %s, current size: %s", file.Path, common.PrettyPrintSize(c.currentSize))
+			if c.Removed != nil {
+				c.Removed(file.Path)
+			}
 		} else {
 			// c.CurrentSize > c.TolerantSize but <= c.MaxSize
 			// Check if the file is older than MaxAge
@@ -142,6 +159,9 @@ func (c *Cleanup) doCleanUp() error {
 				deleted++
 				sizeFreed += file.Size
 				l.Printf("Removed %s, current size: %s", file.Path, common.PrettyPrintSize(c.currentSize))
+				if c.Removed != nil {
+					c.Removed(file.Path)
+				}
 			}
 		}
 	}
@@ -0,0 +1,135 @@
+package common
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+const DefaultHashAlgorithm = "sha256"
+
+// digest sizes in bytes of the algorithms bazel's repository cache knows
+var checksumSizes = map[string]int{
+	"sha1":   sha1.Size,
+	"sha256": sha256.Size,
+	"sha384": sha512.Size384,
+	"sha512": sha512.Size,
+}
+
+// Checksum is the expected hash of a file: its algorithm and lowercase hex digest.
+type Checksum struct {
+	Algorithm string
+	Hex       string
+}
+
+// ParseChecksum accepts the ways a hash is written in build files:
+//   - a hex digest, whose algorithm is told by its length, e.g. `sha256 = "..."`
+//   - a subresource integrity value `<algorithm>-<base64>`, e.g. `integrity = "sha384-..."`
+//   - `<algorithm>:<hex>`
+func ParseChecksum(s string) (*Checksum, error) {
+	s = strings.TrimSpace(s)
+
+	if algorithm, encoded, found := strings.Cut(s, "-"); found {
+		algorithm = strings.ToLower(algorithm)
+		size, ok := checksumSizes[algorithm]
+		if !ok {
+			return nil, fmt.Errorf("unsupported integrity algorithm `%s`", algorithm)
+		}
+		decoded, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid integrity `%s`: %w", s, err)
+		}
+		if len(decoded) != size {
+			return nil, fmt.Errorf("invalid integrity `%s`: %s digest has %d bytes, expected %d", s, algorithm, len(decoded), size)
+		}
+		return &Checksum{Algorithm: algorithm, Hex: hex.EncodeToString(decoded)}, nil
+	}
+
+	if algorithm, digest, found := strings.Cut(s, ":"); found {
+		algorithm = strings.ToLower(algorithm)
+		size, ok := checksumSizes[algorithm]
+		if !ok {
+			return nil, fmt.Errorf("unsupported hash algorithm `%s`", algorithm)
+		}
+		decoded, err := hex.DecodeString(digest)
+		if err != nil || len(decoded) != size {
+			return nil, fmt.Errorf("invalid %s hash `%s`", algorithm, digest)
+		}
+		return &Checksum{Algorithm: algorithm, Hex: hex.EncodeToString(decoded)}, nil
+	}
+
+	decoded, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hash `%s`: %w", s, err)
+	}
+	for algorithm, size := range checksumSizes {
+		if len(decoded) == size {
+			return &Checksum{Algorithm: algorithm, Hex: hex.EncodeToString(decoded)}, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid hash `%s`: unknown digest length %d", s, len(decoded))
+}
+
+// CacheDir returns the directory of the file in bazel's repository cache,
+// `<cacheDir>/content_addressable/<algorithm>/<hex>`.
+func (c *Checksum) CacheDir(cacheDir string) string {
+	return path.Join(cacheDir, "content_addressable", c.Algorithm, c.Hex)
+}
+
+func (c *Checksum) String() string {
+	return fmt.Sprintf("%s:%s", c.Algorithm, c.Hex)
+}
+
+// IdHash returns the hash of a canonical id, which bazel's repository cache keeps
+// next to the entry as an empty file `id-<hash>`. It uses the algorithm of the entry.
+func (c *Checksum) IdHash(canonicalId string) (string, error) {
+	hash, err := NewHash(c.Algorithm)
+	if err != nil {
+		return "", err
+	}
+	hash.Write([]byte(canonicalId))
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
+
+// NewHash returns a hash.Hash of algorithm, e.g. `sha256`.
+func NewHash(algorithm string) (hash.Hash, error) {
+	switch strings.ToLower(algorithm) {
+	case "sha1":
+		return sha1.New(), nil
+	case "", "sha256":
+		return sha256.New(), nil
+	case "sha384":
+		return sha512.New384(), nil
+	case "sha512":
+		return sha512.New(), nil
+	}
+	return nil, fmt.Errorf("unsupported hash algorithm `%s`", algorithm)
+}
+
+// HashOfFileWithAlgorithm is HashOfFile with another algorithm than sha256.
+func HashOfFileWithAlgorithm(p string, algorithm string) (string, error) {
+	hash, err := NewHash(algorithm)
+	if err != nil {
+		return "", err
+	}
+
+	file, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
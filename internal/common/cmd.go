@@ -1,39 +1,103 @@
 package common
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"io"
 	"log"
-	"os"
 	"os/exec"
+	"strings"
+	"time"
 )
 
+// how much of the end of stderr is kept for the error of a failed command
+const stderrTailSize = 2048
+
 func RunCmd(cmdStr string, args []string, callback func(stdout io.ReadCloser)) error {
-	oldPrefix := log.Prefix()
-	log.SetPrefix("common.RunCmd: ")
-	defer log.SetPrefix(oldPrefix)
+	return RunCmdInDir("/", cmdStr, args, callback)
+}
 
-	cmd := exec.Command(cmdStr, args...)
-	cmd.Dir = "/"
+// RunCmdInDir runs a command in dir. stdout of the command is passed to callback,
+// and the command is waited for after callback returns.
+func RunCmdInDir(dir string, cmdStr string, args []string, callback func(stdout io.ReadCloser)) error {
+	return RunCmdWithTimeout(dir, 0, cmdStr, args, callback)
+}
+
+// RunCmdWithTimeout is RunCmdInDir, killing the command if it runs longer than
+// timeout, unless timeout is 0. If the command fails, the error ends with the
+// last lines the command wrote to stderr.
+func RunCmdWithTimeout(dir string, timeout time.Duration, cmdStr string, args []string, callback func(stdout io.ReadCloser)) error {
+	return RunCmdWithLogger(log.Default(), dir, timeout, cmdStr, args, callback)
+}
+
+// RunCmdWithLogger is RunCmdWithTimeout, printing its logs and the stderr of the
+// command to the output of logger, or of the standard logger if it is nil.
+func RunCmdWithLogger(logger *log.Logger, dir string, timeout time.Duration, cmdStr string, args []string, callback func(stdout io.ReadCloser)) error {
+	if logger == nil {
+		logger = log.Default()
+	}
+	l := log.New(logger.Writer(), "common.RunCmd: ", logger.Flags())
+
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
 
-	cmd.Stderr = os.Stderr
+	cmd := exec.CommandContext(ctx, cmdStr, args...)
+	cmd.Dir = dir
+	// don't wait forever for children of a killed command which keep stdout open
+	cmd.WaitDelay = 5 * time.Second
+
+	stderr := &tailWriter{max: stderrTailSize}
+	cmd.Stderr = io.MultiWriter(logger.Writer(), stderr)
 
 	stdoutPipe, err := cmd.StdoutPipe()
 	if err != nil {
-		log.Printf("failed to create stdout pipe: %v", err)
+		l.Printf("failed to create stdout pipe: %v", err)
 		return err
 	}
 
 	if err := cmd.Start(); err != nil {
-		log.Printf("failed to start cmd `%s`, error: %v", cmdStr, err)
+		l.Printf("failed to start cmd `%s`, error: %v", cmdStr, err)
 		return err
 	}
 
-	go callback(stdoutPipe)
+	// all reads from the pipe must be done before calling Wait
+	callback(stdoutPipe)
 
 	if err := cmd.Wait(); err != nil {
-		log.Printf("failed to wait for cmd `%s`, error: %v", cmdStr, err)
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			err = fmt.Errorf("cmd `%s` timed out after %s: %w", cmdStr, timeout, err)
+		} else {
+			err = fmt.Errorf("cmd `%s` failed: %w", cmdStr, err)
+		}
+		if tail := strings.TrimSpace(stderr.String()); tail != "" {
+			err = fmt.Errorf("%w, stderr:\n%s", err, tail)
+		}
+		l.Printf("failed to wait for cmd `%s`, error: %v", cmdStr, err)
 		return err
 	}
 
 	return nil
 }
+
+// tailWriter keeps the last max bytes written to it.
+type tailWriter struct {
+	max int
+	buf []byte
+}
+
+func (w *tailWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	if len(w.buf) > w.max {
+		w.buf = w.buf[len(w.buf)-w.max:]
+	}
+	return len(p), nil
+}
+
+func (w *tailWriter) String() string {
+	return string(w.buf)
+}
@@ -1,9 +1,7 @@
 package common
 
 import (
-	"crypto/sha256"
 	"fmt"
-	"io"
 	"log"
 	"os"
 )
@@ -34,16 +32,5 @@ func PrettyPrintSize(size int64) string {
 }
 
 func HashOfFile(path string) (string, error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return "", err
-	}
-	defer file.Close()
-
-	hash := sha256.New()
-	if _, err := io.Copy(hash, file); err != nil {
-		return "", err
-	}
-
-	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+	return HashOfFileWithAlgorithm(path, DefaultHashAlgorithm)
 }
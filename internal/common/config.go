@@ -2,6 +2,8 @@ package common
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
 )
 
@@ -9,8 +11,8 @@ import (
 type ServerConfig struct {
 	Server struct {
 		Port       int    `json:"port"`
-		Host       string `json:"host"`
-		Timeout    int    `json:"timeout"`
+		Host       string `json:"host"`    // address to listen on, all interfaces if empty
+		Timeout    int    `json:"timeout"` // seconds after which a download is canceled, 0 for no limit
 		Downloader string `json:"downloader"`
 		Workdir    string `json:"workdir"`
 		Scheduler  struct {
@@ -18,7 +20,8 @@ type ServerConfig struct {
 			StartTime string `json:"start_time"`
 			EndTime   string `json:"end_time"`
 		} `json:"scheduler"`
-		Cleanup CleanupConfig `json:"cleanup"` // Added field for cleanup configuration
+		Cleanup     CleanupConfig     `json:"cleanup"` // Added field for cleanup configuration
+		Concurrency ConcurrencyConfig `json:"concurrency"`
 	} `json:"server"`
 	Downloaders []DownloaderConfig `json:"downloaders"`
 
@@ -33,6 +36,14 @@ type CleanupConfig struct {
 	MaxAge       int   `json:"max_age"`
 }
 
+// ConcurrencyConfig bounds how many items are analyzed and downloaded at once.
+type ConcurrencyConfig struct {
+	Workers int `json:"workers"` // items at once, 1 if not set
+	// downloads at once from a host, e.g. {"github.com": 2}, DefaultHostLimit for other hosts
+	Hosts            map[string]int `json:"hosts"`
+	DefaultHostLimit int            `json:"default_host_limit"` // 0 for no limit but Workers
+}
+
 type DownloaderConfig struct {
 	Name        string   `json:"name"`
 	Cmd         string   `json:"cmd"`
@@ -53,22 +64,65 @@ type BazelCommandsConfig struct {
 // Structs for prefetch.json
 
 type Package struct {
-	Name              string        `json:"name"`
-	HashMatcherConfig MatcherConfig `json:"hash_matcher"`
-	UrlMatcherConfig  MatcherConfig `json:"url_matcher"`
+	Name              string              `json:"name"`
+	Params            []ParamConfig       `json:"params"`
+	Matrix            map[string][]string `json:"matrix"` // e.g. {"os": ["linux", "darwin"]}, see PrefetchConfig
+	HashMatcherConfig MatcherConfig       `json:"hash_matcher"`
+	UrlMatcherConfig  MatcherConfig       `json:"url_matcher"`
+}
+
+// ParamConfig is a named matcher, whose value the other matchers of a package use as `{NAME}`.
+type ParamConfig struct {
+	Name string `json:"name"`
+	MatcherConfig
 }
 
 type MatcherConfig struct {
-	Type        string `json:"type"`
-	File        string `json:"file"`
-	Format      string `json:"format"`
-	Regex       string `json:"regex"`
-	AnchorRegex string `json:"anchor_regex"`
-	MaxLines    int    `json:"max_lines"`
+	Type        string   `json:"type"`
+	File        FileList `json:"file"` // one or more files, which can be globs like `third_party/**/deps.bzl`
+	Format      string   `json:"format"`
+	Regex       string   `json:"regex"`
+	AnchorRegex string   `json:"anchor_regex"`
+	MaxLines    int      `json:"max_lines"`
+	Path        string   `json:"path"` // path expression into a JSON, YAML or TOML file, e.g. `$.tools[*].url`
+
+	// command to run in the source dir: before reading the file, or printing the items of an exec matcher
+	Cmd []string `json:"cmd"`
+	// seconds after which Cmd of an exec matcher is killed
+	Timeout int `json:"timeout"`
+
+	// package registry of lockfile matchers, for packages whose URL the lockfile does not record
+	Registry string `json:"registry"`
+}
+
+// FileList is the `file` of a matcher: a single string, or a list of them.
+type FileList []string
+
+func (f *FileList) UnmarshalJSON(data []byte) error {
+	var file string
+	if err := json.Unmarshal(data, &file); err == nil {
+		*f = nil
+		if file != "" {
+			*f = FileList{file}
+		}
+		return nil
+	}
+
+	var files []string
+	if err := json.Unmarshal(data, &files); err != nil {
+		return fmt.Errorf("`file` must be a string or a list of strings: %w", err)
+	}
+	*f = files
+	return nil
 }
 
 type PrefetchConfig struct {
+	// Items with a matrix are prefetched once for every combination of its values,
+	// which their matchers use as `{os}`, `{arch}`, ...
 	Items []*Package `json:"items"`
+
+	// MODULE.bazel.lock in the source tree is analyzed unless this is set
+	DisableBzlmodLockfile bool `json:"disable_bzlmod_lockfile"`
 }
 
 func ReadServerConfigAll(serverConfigPath string, prefetchConfigPath string) (*ServerConfig, error) {
@@ -89,32 +143,46 @@ func ReadServerConfigAll(serverConfigPath string, prefetchConfigPath string) (*S
 
 // Function to read server.json
 func ReadServerConfigJson(filePath string) (*ServerConfig, error) {
-	file, err := os.Open(filePath)
-	if err != nil {
+	var config ServerConfig
+	if err := readConfigJson(filePath, &config, config.Validate); err != nil {
 		return nil, err
 	}
-	defer file.Close()
+	return &config, nil
+}
 
-	var config ServerConfig
-	if err := json.NewDecoder(file).Decode(&config); err != nil {
+// Function to read prefetch.json. Its matchers are validated by the prefetcher.
+func ReadPrefetchConfigJson(filePath string) (*PrefetchConfig, error) {
+	var config PrefetchConfig
+	if err := readConfigJson(filePath, &config, nil); err != nil {
 		return nil, err
 	}
 	return &config, nil
 }
 
-// Function to read prefetch.json
-func ReadPrefetchConfigJson(filePath string) (*PrefetchConfig, error) {
-	file, err := os.Open(filePath)
+// readConfigJson decodes a config file into config, and returns every unknown key
+// and every problem found by validate.
+func readConfigJson(filePath string, config interface{}, validate func() ConfigErrors) error {
+	data, err := os.ReadFile(filePath)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer file.Close()
 
-	var config PrefetchConfig
-	if err := json.NewDecoder(file).Decode(&config); err != nil {
-		return nil, err
+	errs, err := decodeConfig(data, config)
+	var typeErr *ConfigError
+	if errors.As(err, &typeErr) {
+		// the config is only partly decoded, so it can't be validated
+		return fmt.Errorf("invalid config %s: %w", filePath, append(errs, typeErr))
 	}
-	return &config, nil
+	if err != nil {
+		return fmt.Errorf("failed to decode %s: %w", filePath, err)
+	}
+	if validate != nil {
+		errs = append(errs, validate()...)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid config %s: %w", filePath, errs)
+	}
+	return nil
 }
 
 // Function to read bazel_commands.json
@@ -126,7 +194,9 @@ func ReadBazelCommandsConfigJson(filePath string) (*BazelCommandsConfig, error)
 	defer file.Close()
 
 	var config BazelCommandsConfig
-	if err := json.NewDecoder(file).Decode(&config); err != nil {
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
 		return nil, err
 	}
 	return &config, nil
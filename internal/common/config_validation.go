@@ -0,0 +1,246 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SchedulerTimeLayout is the layout of the start and end time of the scheduler.
+const SchedulerTimeLayout = "15:04"
+
+// BuiltinHttpDownloader is the name of the downloader which runs no command, and
+// needs no entry in `downloaders` but for its args.
+const BuiltinHttpDownloader = "http"
+
+// ConfigError is a problem of a config file at a JSON path in it, e.g. `items[2].url_matcher.regex`.
+type ConfigError struct {
+	Path    string
+	Message string
+}
+
+func (e *ConfigError) Error() string {
+	if e.Path == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+// ConfigErrors are all problems found in a config file.
+type ConfigErrors []*ConfigError
+
+func (e ConfigErrors) Error() string {
+	lines := make([]string, 0, len(e)+1)
+	if len(e) == 1 {
+		lines = append(lines, "1 problem found:")
+	} else {
+		lines = append(lines, fmt.Sprintf("%d problems found:", len(e)))
+	}
+	for _, err := range e {
+		lines = append(lines, "  "+err.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (e *ConfigErrors) Addf(path string, format string, args ...interface{}) {
+	*e = append(*e, &ConfigError{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// Err returns e, or nil if there are no problems.
+func (e ConfigErrors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// ConfigPath returns the path of key in the object at parent.
+func ConfigPath(parent string, key string) string {
+	if parent == "" {
+		return key
+	}
+	return parent + "." + key
+}
+
+// ConfigIndexPath returns the path of the i-th value of the list at parent.
+func ConfigIndexPath(parent string, i int) string {
+	return fmt.Sprintf("%s[%d]", parent, i)
+}
+
+// decodeConfig decodes a config file into config. Unknown keys are returned as
+// problems, after decoding the rest. err is set if the file can't be decoded.
+func decodeConfig(data []byte, config interface{}) (unknown ConfigErrors, err error) {
+	var raw interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	findUnknownFields(raw, reflect.TypeOf(config), "", &unknown)
+
+	if err := json.Unmarshal(data, config); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return unknown, &ConfigError{Path: typeErr.Field, Message: fmt.Sprintf("expected %s, got %s", typeErr.Type, typeErr.Value)}
+		}
+		return unknown, err
+	}
+	return unknown, nil
+}
+
+// findUnknownFields adds a problem for every key of value which has no field in t.
+func findUnknownFields(value interface{}, t reflect.Type, path string, errs *ConfigErrors) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch value := value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		switch t.Kind() {
+		case reflect.Struct:
+			fields := jsonFields(t)
+			for _, key := range keys {
+				field, ok := fields[strings.ToLower(key)]
+				if !ok {
+					errs.Addf(ConfigPath(path, key), "unknown field")
+					continue
+				}
+				findUnknownFields(value[key], field, ConfigPath(path, key), errs)
+			}
+		case reflect.Map:
+			for _, key := range keys {
+				findUnknownFields(value[key], t.Elem(), ConfigPath(path, key), errs)
+			}
+		}
+	case []interface{}:
+		if t.Kind() == reflect.Slice {
+			for i, item := range value {
+				findUnknownFields(item, t.Elem(), ConfigIndexPath(path, i), errs)
+			}
+		}
+	}
+}
+
+// jsonFields returns the types of the fields of a struct by their lowercase JSON
+// keys, which encoding/json matches case-insensitively.
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" || !field.IsExported() {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			for key, embedded := range jsonFields(field.Type) {
+				fields[key] = embedded
+			}
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[strings.ToLower(name)] = field.Type
+	}
+	return fields
+}
+
+// Validate returns every problem of the server config.
+func (c *ServerConfig) Validate() ConfigErrors {
+	var errs ConfigErrors
+	server := &c.Server
+
+	if server.Port <= 0 || server.Port > 65535 {
+		errs.Addf("server.port", "must be between 1 and 65535, got %d", server.Port)
+	}
+	if server.Timeout < 0 {
+		errs.Addf("server.timeout", "must not be negative, got %d", server.Timeout)
+	}
+	if server.Workdir == "" {
+		errs.Addf("server.workdir", "is required")
+	}
+
+	names := make([]string, 0, len(c.Downloaders))
+	for _, downloader := range c.Downloaders {
+		names = append(names, downloader.Name)
+	}
+	if server.Downloader == "" {
+		errs.Addf("server.downloader", "is required")
+	} else if server.Downloader != BuiltinHttpDownloader && !slices.Contains(names, server.Downloader) {
+		errs.Addf("server.downloader", "unknown downloader `%s`, configured downloaders: %s", server.Downloader, strings.Join(names, ", "))
+	}
+
+	if server.Scheduler.Interval <= 0 {
+		errs.Addf("server.scheduler.interval", "must be greater than 0, got %d", server.Scheduler.Interval)
+	}
+	if _, err := time.Parse(SchedulerTimeLayout, server.Scheduler.StartTime); err != nil {
+		errs.Addf("server.scheduler.start_time", "must be HH:MM, got `%s`", server.Scheduler.StartTime)
+	}
+	if _, err := time.Parse(SchedulerTimeLayout, server.Scheduler.EndTime); err != nil {
+		errs.Addf("server.scheduler.end_time", "must be HH:MM, got `%s`", server.Scheduler.EndTime)
+	}
+
+	if cleanup := server.Cleanup; cleanup.Enabled {
+		if cleanup.MaxSize <= 0 {
+			errs.Addf("server.cleanup.max_size", "must be greater than 0, got %d", cleanup.MaxSize)
+		}
+		if cleanup.TolerantSize < 0 || cleanup.TolerantSize > cleanup.MaxSize {
+			errs.Addf("server.cleanup.tolerant_size", "must be between 0 and max_size (%d), got %d", cleanup.MaxSize, cleanup.TolerantSize)
+		}
+		if cleanup.MaxAge < 0 {
+			errs.Addf("server.cleanup.max_age", "must not be negative, got %d", cleanup.MaxAge)
+		}
+	}
+
+	concurrency := server.Concurrency
+	if concurrency.Workers < 0 {
+		errs.Addf("server.concurrency.workers", "must not be negative, got %d", concurrency.Workers)
+	}
+	if concurrency.DefaultHostLimit < 0 {
+		errs.Addf("server.concurrency.default_host_limit", "must not be negative, got %d", concurrency.DefaultHostLimit)
+	}
+	hosts := make([]string, 0, len(concurrency.Hosts))
+	for host := range concurrency.Hosts {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	for _, host := range hosts {
+		if limit := concurrency.Hosts[host]; limit < 1 {
+			errs.Addf(ConfigPath("server.concurrency.hosts", host), "must be greater than 0, got %d", limit)
+		}
+	}
+
+	for i, downloader := range c.Downloaders {
+		path := ConfigIndexPath("downloaders", i)
+		if downloader.Name == "" {
+			errs.Addf(ConfigPath(path, "name"), "is required")
+		} else if slices.Contains(names[:i], downloader.Name) {
+			errs.Addf(ConfigPath(path, "name"), "duplicated downloader `%s`", downloader.Name)
+		}
+		if downloader.Cmd == "" && downloader.Name != BuiltinHttpDownloader {
+			errs.Addf(ConfigPath(path, "cmd"), "is required")
+		}
+		for j, arg := range downloader.Args {
+			matcherPath := ConfigPath(ConfigIndexPath(ConfigPath(path, "args"), j), "matcher")
+			if arg.Matcher.Type != "url" {
+				errs.Addf(ConfigPath(matcherPath, "type"), "unsupported matcher type `%s`, only `url` is supported", arg.Matcher.Type)
+			}
+			if _, err := regexp.Compile(arg.Matcher.Pattern); err != nil {
+				errs.Addf(ConfigPath(matcherPath, "pattern"), "invalid regex: %v", err)
+			}
+		}
+	}
+
+	return errs
+}
@@ -48,29 +48,49 @@ func Imafish() string {
 }
 
 func LogSeparator(text string) {
+	LogSeparatorTo(log.Default(), text)
+}
+
+// LogSeparatorTo is LogSeparator, printing to logger.
+func LogSeparatorTo(logger *log.Logger, text string) {
 	n := 21 - len(text)
 	if n < 0 {
 		n = 0
 	} else {
 		n = n / 2
 	}
-	log.Print(strings.Repeat("=", 21))
-	log.Print(strings.Repeat(" ", n) + text)
-	log.Print(strings.Repeat("=", 21))
+	logger.Print(strings.Repeat("=", 21))
+	logger.Print(strings.Repeat(" ", n) + text)
+	logger.Print(strings.Repeat("=", 21))
 }
 
 type LoggerWithPrefix struct {
 	Prefix string
+	// Logger is printed to, the standard logger if nil
+	Logger *log.Logger
 }
 
 func NewLoggerWithPrefixAndColor(prefix string) *LoggerWithPrefix {
 	return &LoggerWithPrefix{Prefix: colorPrefix(prefix)}
 }
 
+// NewLoggerWithPrefixAndColorTo is NewLoggerWithPrefixAndColor, printing to logger,
+// e.g. the logger of an item processed in parallel with others.
+func NewLoggerWithPrefixAndColorTo(logger *log.Logger, prefix string) *LoggerWithPrefix {
+	return &LoggerWithPrefix{Prefix: colorPrefix(prefix), Logger: logger}
+}
+
 func colorPrefix(prefix string) string {
 	return lightBlue + prefix + reset
 }
 
+func (l *LoggerWithPrefix) logger() *log.Logger {
+	if l.Logger == nil {
+		return log.Default()
+	}
+	return l.Logger
+}
+
 func (l *LoggerWithPrefix) SmallSeparator(text string, args ...interface{}) {
 	n := 49 - len(text) - 4
 	if n < 7 {
@@ -82,26 +102,26 @@ func (l *LoggerWithPrefix) SmallSeparator(text string, args ...interface{}) {
 	}
 	n = n / 2
 
-	log.Print(l.Prefix, blue, strings.Repeat(">", n), reset, "  ", fmt.Sprintf(text, args...), "  ", blue, strings.Repeat("<", m), reset)
+	l.logger().Print(l.Prefix, blue, strings.Repeat(">", n), reset, "  ", fmt.Sprintf(text, args...), "  ", blue, strings.Repeat("<", m), reset)
 }
 
 func (l *LoggerWithPrefix) Printf(format string, args ...interface{}) {
 	prefixedFormat := l.Prefix + format
-	log.Printf(prefixedFormat, args...)
+	l.logger().Printf(prefixedFormat, args...)
 }
 func (l *LoggerWithPrefix) Print(args ...interface{}) {
 	prefixedMessage := l.Prefix + fmt.Sprint(args...)
-	log.Print(prefixedMessage)
+	l.logger().Print(prefixedMessage)
 }
 func (l *LoggerWithPrefix) Println(args ...interface{}) {
 	prefixedMessage := l.Prefix + fmt.Sprint(args...)
-	log.Println(prefixedMessage)
+	l.logger().Println(prefixedMessage)
 }
 func (l *LoggerWithPrefix) Fatalf(format string, args ...interface{}) {
 	prefixedFormat := l.Prefix + format
-	log.Fatalf(prefixedFormat, args...)
+	l.logger().Fatalf(prefixedFormat, args...)
 }
 func (l *LoggerWithPrefix) Fatal(args ...interface{}) {
 	prefixedMessage := l.Prefix + fmt.Sprint(args...)
-	log.Fatal(prefixedMessage)
+	l.logger().Fatal(prefixedMessage)
 }
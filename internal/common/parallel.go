@@ -0,0 +1,144 @@
+package common
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/url"
+	"sync"
+)
+
+// RunParallel calls fn for 0 to n-1, at most workers at once, and returns when all
+// calls returned. workers below 1 run the calls one by one.
+func RunParallel(n int, workers int, fn func(i int)) {
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > n {
+		workers = n
+	}
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+}
+
+// HostLimiter bounds how many downloads are done at once from each host.
+type HostLimiter struct {
+	limits       map[string]int
+	defaultLimit int
+
+	mtx   sync.Mutex
+	slots map[string]chan struct{}
+}
+
+// NewHostLimiter returns a HostLimiter with the limits of hosts, e.g. {"github.com": 2},
+// and defaultLimit for the other hosts. A limit below 1 does not limit a host.
+func NewHostLimiter(limits map[string]int, defaultLimit int) *HostLimiter {
+	return &HostLimiter{
+		limits:       limits,
+		defaultLimit: defaultLimit,
+		slots:        make(map[string]chan struct{}),
+	}
+}
+
+// Acquire waits until the host of rawUrl is below its limit, and returns the
+// function releasing it. A nil HostLimiter limits nothing.
+func (h *HostLimiter) Acquire(rawUrl string) (release func()) {
+	if h == nil {
+		return func() {}
+	}
+	host := rawUrl
+	if u, err := url.Parse(rawUrl); err == nil && u.Hostname() != "" {
+		host = u.Hostname()
+	}
+
+	h.mtx.Lock()
+	slots, ok := h.slots[host]
+	if !ok {
+		limit, ok := h.limits[host]
+		if !ok {
+			limit = h.defaultLimit
+		}
+		if limit > 0 {
+			slots = make(chan struct{}, limit)
+		}
+		h.slots[host] = slots
+	}
+	h.mtx.Unlock()
+
+	if slots == nil {
+		return func() {}
+	}
+	slots <- struct{}{}
+	return func() { <-slots }
+}
+
+// OrderedLogs keeps the logs of tasks run in parallel apart, and prints them in the
+// order of the tasks: the first unfinished task logs as it goes, the others are
+// held back until the tasks before them are done.
+type OrderedLogs struct {
+	out io.Writer
+
+	mtx     sync.Mutex
+	buffers []bytes.Buffer
+	done    []bool
+	current int
+}
+
+// NewOrderedLogs returns OrderedLogs for n tasks, printing to the output of the log package.
+func NewOrderedLogs(n int) *OrderedLogs {
+	return &OrderedLogs{
+		out:     log.Writer(),
+		buffers: make([]bytes.Buffer, n),
+		done:    make([]bool, n),
+	}
+}
+
+// Logger returns the logger of task i, with the flags and prefix of the log package.
+func (o *OrderedLogs) Logger(i int) *log.Logger {
+	return log.New(&orderedWriter{logs: o, task: i}, log.Prefix(), log.Flags())
+}
+
+// Done marks task i as done, and prints the logs of the tasks it held back.
+func (o *OrderedLogs) Done(i int) {
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+
+	o.done[i] = true
+	for o.current < len(o.done) && o.done[o.current] {
+		o.current++
+		if o.current < len(o.buffers) {
+			o.out.Write(o.buffers[o.current].Bytes())
+			o.buffers[o.current].Reset()
+		}
+	}
+}
+
+type orderedWriter struct {
+	logs *OrderedLogs
+	task int
+}
+
+func (w *orderedWriter) Write(p []byte) (int, error) {
+	w.logs.mtx.Lock()
+	defer w.logs.mtx.Unlock()
+
+	if w.task == w.logs.current {
+		return w.logs.out.Write(p)
+	}
+	return w.logs.buffers[w.task].Write(p)
+}
@@ -0,0 +1,247 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// CacheIndexEntry is a file of bazel's repository cache, and one of the canonical
+// ids it was downloaded for. Bazel marks them with an empty `id-<IdHash>` file next
+// to the file, IdHash being the hash of the canonical id, which is the URL for rules
+// with a single URL. Files without id files have an entry with an empty IdHash.
+type CacheIndexEntry struct {
+	ID          int64     `json:"id"`
+	Algorithm   string    `json:"algorithm"` // e.g. `sha256`
+	Hash        string    `json:"hash"`      // hash of the content
+	IdHash      string    `json:"id_hash"`
+	CanonicalId string    `json:"canonical_id"` // empty if the entry was only scanned
+	Size        int64     `json:"size"`
+	IndexedAt   time.Time `json:"indexed_at"`
+}
+
+// CacheIndexTable indexes the files of a repository cache, so they can be looked up
+// without walking it. It has to be told when files are added or evicted, or rescan it.
+type CacheIndexTable struct {
+	db *sql.DB
+}
+
+func NewCacheIndexTable(db *sql.DB) *CacheIndexTable {
+	return &CacheIndexTable{db: db}
+}
+
+func (t *CacheIndexTable) Create() error {
+	query := `CREATE TABLE IF NOT EXISTS cache_index (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		algorithm TEXT,
+		hash TEXT,
+		id_hash TEXT,
+		canonical_id TEXT DEFAULT '',
+		size INTEGER,
+		indexed_at DATETIME,
+		UNIQUE (algorithm, hash, id_hash)
+	)`
+	if _, err := t.db.Exec(query); err != nil {
+		return err
+	}
+	_, err := t.db.Exec(`CREATE INDEX IF NOT EXISTS cache_index_id_hash ON cache_index (algorithm, id_hash)`)
+	return err
+}
+
+func (t *CacheIndexTable) Drop() error {
+	query := `DROP TABLE IF EXISTS cache_index`
+	_, err := t.db.Exec(query)
+	return err
+}
+
+// Add records a file added to the cache.
+func (t *CacheIndexTable) Add(entry *CacheIndexEntry) error {
+	entry.IndexedAt = time.Now().UTC()
+	return upsertCacheIndexEntry(t.db, entry)
+}
+
+// upsertCacheIndexEntry inserts entry, or updates the entry of the same file and id.
+// The canonical id is kept if entry does not know it.
+func upsertCacheIndexEntry(db interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}, entry *CacheIndexEntry) error {
+	query := `UPDATE cache_index SET
+			  canonical_id = CASE WHEN ? = '' THEN canonical_id ELSE ? END,
+			  size = ?,
+			  indexed_at = ?
+			  WHERE algorithm = ? AND hash = ? AND id_hash = ?`
+	result, err := db.Exec(query, entry.CanonicalId, entry.CanonicalId, entry.Size, entry.IndexedAt, entry.Algorithm, entry.Hash, entry.IdHash)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+
+	query = `INSERT INTO cache_index (algorithm, hash, id_hash, canonical_id, size, indexed_at)
+			 VALUES (?, ?, ?, ?, ?, ?)`
+	result, err = db.Exec(query, entry.Algorithm, entry.Hash, entry.IdHash, entry.CanonicalId, entry.Size, entry.IndexedAt)
+	if err != nil {
+		return err
+	}
+	entry.ID, err = result.LastInsertId()
+	return err
+}
+
+// Remove forgets a file evicted from the cache, with all of its ids.
+func (t *CacheIndexTable) Remove(algorithm string, hash string) error {
+	query := `DELETE FROM cache_index WHERE algorithm = ? AND hash = ?`
+	_, err := t.db.Exec(query, algorithm, hash)
+	return err
+}
+
+// Has tells if the cache has the file of hash, marked with the id file of idHash.
+func (t *CacheIndexTable) Has(algorithm string, hash string, idHash string) (bool, error) {
+	query := `SELECT COUNT(*) FROM cache_index WHERE algorithm = ? AND hash = ? AND id_hash = ?`
+	var count int
+	if err := t.db.QueryRow(query, algorithm, hash, idHash).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// FindByIdHash returns the hashes of the files marked with the id file of idHash.
+func (t *CacheIndexTable) FindByIdHash(algorithm string, idHash string) ([]string, error) {
+	entries, err := t.query(`SELECT id, algorithm, hash, id_hash, canonical_id, size, indexed_at FROM cache_index WHERE algorithm = ? AND id_hash = ?`, algorithm, idHash)
+	if err != nil {
+		return nil, err
+	}
+	hashes := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		hashes = append(hashes, entry.Hash)
+	}
+	return hashes, nil
+}
+
+func (t *CacheIndexTable) GetAll() ([]CacheIndexEntry, error) {
+	query := `SELECT id, algorithm, hash, id_hash, canonical_id, size, indexed_at FROM cache_index ORDER BY algorithm, hash, id_hash`
+	return t.query(query)
+}
+
+// Scan syncs the index with the repository cache at cacheDir, e.g. after bazel
+// wrote to it. It lists every entry directory, and stats the files in them.
+func (t *CacheIndexTable) Scan(cacheDir string) error {
+	// in UTC, so the times stored by the driver compare as strings
+	scanStart := time.Now().UTC()
+	tx, err := t.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	root := filepath.Join(cacheDir, "content_addressable")
+	algorithms, err := os.ReadDir(root)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, algorithm := range algorithms {
+		if !algorithm.IsDir() {
+			continue
+		}
+		entries, err := os.ReadDir(filepath.Join(root, algorithm.Name()))
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				continue
+			}
+			scanned, err := scanCacheEntry(filepath.Join(root, algorithm.Name(), entry.Name()), algorithm.Name(), entry.Name(), scanStart)
+			if err != nil {
+				return err
+			}
+			for _, e := range scanned {
+				if err := upsertCacheIndexEntry(tx, e); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	// entries which were not seen are gone
+	if _, err := tx.Exec(`DELETE FROM cache_index WHERE indexed_at < ?`, scanStart); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// scanCacheEntry returns the index entries of an entry directory of the cache,
+// none if its file is missing.
+func scanCacheEntry(dir string, algorithm string, hash string, indexedAt time.Time) ([]*CacheIndexEntry, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var size int64 = -1
+	var idHashes []string
+	for _, file := range files {
+		if file.Name() == "file" {
+			info, err := file.Info()
+			if err != nil {
+				return nil, err
+			}
+			size = info.Size()
+		} else if strings.HasPrefix(file.Name(), "id-") {
+			idHashes = append(idHashes, strings.TrimPrefix(file.Name(), "id-"))
+		}
+	}
+	if size < 0 {
+		return nil, nil
+	}
+	if len(idHashes) == 0 {
+		idHashes = []string{""}
+	}
+
+	entries := make([]*CacheIndexEntry, 0, len(idHashes))
+	for _, idHash := range idHashes {
+		entries = append(entries, &CacheIndexEntry{Algorithm: algorithm, Hash: hash, IdHash: idHash, Size: size, IndexedAt: indexedAt})
+	}
+	return entries, nil
+}
+
+func (t *CacheIndexTable) query(query string, args ...interface{}) ([]CacheIndexEntry, error) {
+	rows, err := t.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []CacheIndexEntry
+	for rows.Next() {
+		var entry CacheIndexEntry
+		err := rows.Scan(&entry.ID, &entry.Algorithm, &entry.Hash, &entry.IdHash, &entry.CanonicalId, &entry.Size, &entry.IndexedAt)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+func (t *CacheIndexTable) DebugPrintAll() error {
+	entries, err := t.GetAll()
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		fmt.Printf("ID: %d, Algorithm: %s, Hash: %s, ID Hash: %s, Canonical ID: %s, Size: %d, Indexed At: %s\n",
+			entry.ID, entry.Algorithm, entry.Hash, entry.IdHash, entry.CanonicalId, entry.Size, entry.IndexedAt)
+	}
+
+	return nil
+}
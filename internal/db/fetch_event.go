@@ -0,0 +1,128 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// FetchEvent is a `fetch` event from the build event protocol stream of a bazel command:
+// a URL bazel tried to download, and whether it succeeded.
+type FetchEvent struct {
+	ID         int64     `json:"id"`
+	Command    string    `json:"command"`
+	Url        string    `json:"url"`
+	UrlHash    string    `json:"url_hash"`
+	Downloader string    `json:"downloader"`
+	Success    bool      `json:"success"`
+	FetchedAt  time.Time `json:"fetched_at"`
+}
+
+type FetchEventTable struct {
+	db *sql.DB
+}
+
+func NewFetchEventTable(db *sql.DB) *FetchEventTable {
+	return &FetchEventTable{db: db}
+}
+
+func (t *FetchEventTable) Create() error {
+	query := `CREATE TABLE IF NOT EXISTS fetch_events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		command TEXT,
+		url TEXT,
+		url_hash TEXT,
+		downloader TEXT,
+		success BOOLEAN,
+		fetched_at DATETIME
+	)`
+	_, err := t.db.Exec(query)
+	return err
+}
+
+func (t *FetchEventTable) Drop() error {
+	query := `DROP TABLE IF EXISTS fetch_events`
+	_, err := t.db.Exec(query)
+	return err
+}
+
+// CreateOrUpdate keeps the latest event of each URL of each command.
+func (t *FetchEventTable) CreateOrUpdate(event *FetchEvent) error {
+	event.FetchedAt = time.Now()
+
+	query := `UPDATE fetch_events SET
+			  url_hash = ?,
+			  downloader = ?,
+			  success = ?,
+			  fetched_at = ?
+			  WHERE command = ? AND url = ?`
+	result, err := t.db.Exec(query, event.UrlHash, event.Downloader, event.Success, event.FetchedAt, event.Command, event.Url)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+
+	query = `INSERT INTO fetch_events (command, url, url_hash, downloader, success, fetched_at)
+			 VALUES (?, ?, ?, ?, ?, ?)`
+	result, err = t.db.Exec(query, event.Command, event.Url, event.UrlHash, event.Downloader, event.Success, event.FetchedAt)
+	if err != nil {
+		return err
+	}
+	event.ID, err = result.LastInsertId()
+	return err
+}
+
+func (t *FetchEventTable) GetByCommand(command string) ([]FetchEvent, error) {
+	query := `SELECT id, command, url, url_hash, downloader, success, fetched_at FROM fetch_events WHERE command = ?`
+	return t.query(query, command)
+}
+
+func (t *FetchEventTable) GetFailed() ([]FetchEvent, error) {
+	query := `SELECT id, command, url, url_hash, downloader, success, fetched_at FROM fetch_events WHERE success = 0`
+	return t.query(query)
+}
+
+func (t *FetchEventTable) GetAll() ([]FetchEvent, error) {
+	query := `SELECT id, command, url, url_hash, downloader, success, fetched_at FROM fetch_events`
+	return t.query(query)
+}
+
+func (t *FetchEventTable) query(query string, args ...interface{}) ([]FetchEvent, error) {
+	rows, err := t.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []FetchEvent
+	for rows.Next() {
+		var event FetchEvent
+		err := rows.Scan(&event.ID, &event.Command, &event.Url, &event.UrlHash, &event.Downloader, &event.Success, &event.FetchedAt)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+func (t *FetchEventTable) DebugPrintAll() error {
+	events, err := t.GetAll()
+	if err != nil {
+		return err
+	}
+
+	for _, event := range events {
+		fmt.Printf("ID: %d, Command: %s, URL: %s, URL Hash: %s, Downloader: %s, Success: %t, Fetched At: %s\n",
+			event.ID, event.Command, event.Url, event.UrlHash, event.Downloader, event.Success, event.FetchedAt)
+	}
+
+	return nil
+}
@@ -7,13 +7,17 @@ import (
 )
 
 type Item struct {
-	ID           int64     `json:"id" db:"id"`
-	Size         int64     `json:"size"`
-	Path         string    `json:"path"`
-	Url          string    `json:"url"`
-	Hash         string    `json:"hash"`
-	UrlHash      string    `json:"url_hash"`
-	DownloadedAt time.Time `json:"downloaded_at"`
+	ID              int64     `json:"id" db:"id"`
+	Size            int64     `json:"size"`
+	Path            string    `json:"path"`
+	Url             string    `json:"url"`
+	Urls            string    `json:"urls"`         // all URLs of the rule, separated by spaces
+	CanonicalId     string    `json:"canonical_id"` // the id bazel looks the item up by in its repository cache
+	Hash            string    `json:"hash"`
+	CanonicalIdHash string    `json:"canonical_id_hash"` // hash of CanonicalId, in the name of the `id-<hash>` file
+	Source          string    `json:"source"`            // where the item was found in the source tree
+	ServedBy        string    `json:"served_by"`         // the URL of Urls the content was downloaded from
+	DownloadedAt    time.Time `json:"downloaded_at"`
 }
 
 type ItemTable struct {
@@ -32,8 +36,8 @@ func (t *ItemTable) Create() error {
 	var tableName string
 	err := row.Scan(&tableName)
 	if err == nil && tableName == "items" {
-		// Table already exists, no need to create it
-		return nil
+		// Table already exists, only add the columns of newer versions
+		return t.migrate()
 	}
 
 	// Create the table if it does not exist
@@ -43,13 +47,77 @@ func (t *ItemTable) Create() error {
 		path TEXT,
 		url TEXT,
 		hash TEXT,
-		url_hash TEXT,
+		urls TEXT DEFAULT '',
+		canonical_id TEXT DEFAULT '',
+		canonical_id_hash TEXT,
+		source TEXT DEFAULT '',
+		served_by TEXT DEFAULT '',
 		downloaded_at DATETIME
 	)`
 	_, err = t.db.Exec(query)
 	return err
 }
 
+// columns added to the items table after its first version
+var itemColumnsAdded = []struct {
+	name       string
+	definition string
+}{
+	{"source", "TEXT DEFAULT ''"},
+	{"urls", "TEXT DEFAULT ''"},
+	{"canonical_id", "TEXT DEFAULT ''"},
+	{"served_by", "TEXT DEFAULT ''"},
+}
+
+// columns of the items table renamed after its first version, by their old name
+var itemColumnsRenamed = []struct {
+	oldName string
+	newName string
+}{
+	// it holds the hash of the canonical id, not of the URL
+	{"url_hash", "canonical_id_hash"},
+}
+
+// migrate renames and adds the columns of a table created by an older version.
+func (t *ItemTable) migrate() error {
+	rows, err := t.db.Query(`SELECT name FROM pragma_table_info('items')`)
+	if err != nil {
+		return err
+	}
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		columns[name] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, column := range itemColumnsRenamed {
+		if !columns[column.oldName] || columns[column.newName] {
+			continue
+		}
+		if _, err := t.db.Exec(fmt.Sprintf(`ALTER TABLE items RENAME COLUMN %s TO %s`, column.oldName, column.newName)); err != nil {
+			return err
+		}
+	}
+
+	for _, column := range itemColumnsAdded {
+		if columns[column.name] {
+			continue
+		}
+		if _, err := t.db.Exec(fmt.Sprintf(`ALTER TABLE items ADD COLUMN %s %s`, column.name, column.definition)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (t *ItemTable) Drop() error {
 	query := `DROP TABLE IF EXISTS items`
 	_, err := t.db.Exec(query)
@@ -60,9 +128,9 @@ func (t *ItemTable) Insert(item *Item) error {
 	// Set DownloadedAt to the current time
 	item.DownloadedAt = time.Now()
 
-	query := `INSERT INTO items (size, path, url, hash, url_hash, downloaded_at) 
-			  VALUES (?, ?, ?, ?, ?, ?)`
-	result, err := t.db.Exec(query, item.Size, item.Path, item.Url, item.Hash, item.UrlHash, item.DownloadedAt)
+	query := `INSERT INTO items (size, path, url, urls, canonical_id, hash, canonical_id_hash, source, served_by, downloaded_at) 
+			  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	result, err := t.db.Exec(query, item.Size, item.Path, item.Url, item.Urls, item.CanonicalId, item.Hash, item.CanonicalIdHash, item.Source, item.ServedBy, item.DownloadedAt)
 	if err != nil {
 		return err
 	}
@@ -83,11 +151,15 @@ func (t *ItemTable) CreateOrUpdate(item *Item) error {
 		query := `UPDATE items SET 
 				  size = ?, 
 				  path = ?, 
+				  urls = ?, 
+				  canonical_id = ?, 
 				  hash = ?, 
-				  url_hash = ?, 
+				  canonical_id_hash = ?, 
+				  source = ?, 
+				  served_by = ?, 
 				  downloaded_at = ? 
 				  WHERE url = ?`
-		_, err = t.db.Exec(query, item.Size, item.Path, item.Hash, item.UrlHash, item.DownloadedAt, item.Url)
+		_, err = t.db.Exec(query, item.Size, item.Path, item.Urls, item.CanonicalId, item.Hash, item.CanonicalIdHash, item.Source, item.ServedBy, item.DownloadedAt, item.Url)
 		return err
 	}
 
@@ -96,11 +168,11 @@ func (t *ItemTable) CreateOrUpdate(item *Item) error {
 }
 
 func (t *ItemTable) GetByID(id int64) (*Item, error) {
-	query := `SELECT id, size, path, url, hash, url_hash, downloaded_at FROM items WHERE id = ?`
+	query := `SELECT id, size, path, url, urls, canonical_id, hash, canonical_id_hash, source, served_by, downloaded_at FROM items WHERE id = ?`
 	row := t.db.QueryRow(query, id)
 
 	var item Item
-	err := row.Scan(&item.ID, &item.Size, &item.Path, &item.Url, &item.Hash, &item.UrlHash, &item.DownloadedAt)
+	err := row.Scan(&item.ID, &item.Size, &item.Path, &item.Url, &item.Urls, &item.CanonicalId, &item.Hash, &item.CanonicalIdHash, &item.Source, &item.ServedBy, &item.DownloadedAt)
 	if err != nil {
 		return nil, err
 	}
@@ -108,11 +180,11 @@ func (t *ItemTable) GetByID(id int64) (*Item, error) {
 }
 
 func (t *ItemTable) GetByUrl(url string) (*Item, error) {
-	query := `SELECT id, size, path, url, hash, url_hash, downloaded_at FROM items WHERE url = ?`
+	query := `SELECT id, size, path, url, urls, canonical_id, hash, canonical_id_hash, source, served_by, downloaded_at FROM items WHERE url = ?`
 	row := t.db.QueryRow(query, url)
 
 	var item Item
-	err := row.Scan(&item.ID, &item.Size, &item.Path, &item.Url, &item.Hash, &item.UrlHash, &item.DownloadedAt)
+	err := row.Scan(&item.ID, &item.Size, &item.Path, &item.Url, &item.Urls, &item.CanonicalId, &item.Hash, &item.CanonicalIdHash, &item.Source, &item.ServedBy, &item.DownloadedAt)
 	if err != nil {
 		return nil, err
 	}
@@ -126,7 +198,7 @@ func (t *ItemTable) DeleteByID(id int64) error {
 }
 
 func (t *ItemTable) GetAll() ([]Item, error) {
-	query := `SELECT id, size, path, url, hash, url_hash, downloaded_at FROM items`
+	query := `SELECT id, size, path, url, urls, canonical_id, hash, canonical_id_hash, source, served_by, downloaded_at FROM items`
 	rows, err := t.db.Query(query)
 	if err != nil {
 		return nil, err
@@ -136,7 +208,7 @@ func (t *ItemTable) GetAll() ([]Item, error) {
 	var items []Item
 	for rows.Next() {
 		var item Item
-		err := rows.Scan(&item.ID, &item.Size, &item.Path, &item.Url, &item.Hash, &item.UrlHash, &item.DownloadedAt)
+		err := rows.Scan(&item.ID, &item.Size, &item.Path, &item.Url, &item.Urls, &item.CanonicalId, &item.Hash, &item.CanonicalIdHash, &item.Source, &item.ServedBy, &item.DownloadedAt)
 		if err != nil {
 			return nil, err
 		}
@@ -158,8 +230,8 @@ func (t *ItemTable) DebugPrintAll() error {
 
 	for _, item := range items {
 		// Print each item in a readable format
-		fmt.Printf("ID: %d, Size: %d, Path: %s, URL: %s, Hash: %s, URL Hash: %s, Downloaded At: %s\n",
-			item.ID, item.Size, item.Path, item.Url, item.Hash, item.UrlHash, item.DownloadedAt)
+		fmt.Printf("ID: %d, Size: %d, Path: %s, URL: %s, Canonical ID: %s, Hash: %s, Canonical ID Hash: %s, Source: %s, Served By: %s, Downloaded At: %s\n",
+			item.ID, item.Size, item.Path, item.Url, item.CanonicalId, item.Hash, item.CanonicalIdHash, item.Source, item.ServedBy, item.DownloadedAt)
 	}
 
 	return nil
@@ -0,0 +1,125 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Mirror is a URL of an item which the server tried to download it from,
+// and whether the content it served passed hash verification.
+type Mirror struct {
+	ID        int64     `json:"id"`
+	Url       string    `json:"url"`
+	ItemUrl   string    `json:"item_url"` // Url of the item, see Item
+	Broken    bool      `json:"broken"`
+	Error     string    `json:"error"` // why the last download failed, if Broken
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+type MirrorTable struct {
+	db *sql.DB
+}
+
+func NewMirrorTable(db *sql.DB) *MirrorTable {
+	return &MirrorTable{db: db}
+}
+
+func (t *MirrorTable) Create() error {
+	query := `CREATE TABLE IF NOT EXISTS mirrors (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		url TEXT,
+		item_url TEXT,
+		broken BOOLEAN,
+		error TEXT,
+		checked_at DATETIME
+	)`
+	_, err := t.db.Exec(query)
+	return err
+}
+
+func (t *MirrorTable) Drop() error {
+	query := `DROP TABLE IF EXISTS mirrors`
+	_, err := t.db.Exec(query)
+	return err
+}
+
+// CreateOrUpdate keeps the latest result of each mirror of each item.
+func (t *MirrorTable) CreateOrUpdate(mirror *Mirror) error {
+	mirror.CheckedAt = time.Now()
+
+	query := `UPDATE mirrors SET
+			  broken = ?,
+			  error = ?,
+			  checked_at = ?
+			  WHERE item_url = ? AND url = ?`
+	result, err := t.db.Exec(query, mirror.Broken, mirror.Error, mirror.CheckedAt, mirror.ItemUrl, mirror.Url)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+
+	query = `INSERT INTO mirrors (url, item_url, broken, error, checked_at)
+			 VALUES (?, ?, ?, ?, ?)`
+	result, err = t.db.Exec(query, mirror.Url, mirror.ItemUrl, mirror.Broken, mirror.Error, mirror.CheckedAt)
+	if err != nil {
+		return err
+	}
+	mirror.ID, err = result.LastInsertId()
+	return err
+}
+
+func (t *MirrorTable) GetByItemUrl(itemUrl string) ([]Mirror, error) {
+	query := `SELECT id, url, item_url, broken, error, checked_at FROM mirrors WHERE item_url = ?`
+	return t.query(query, itemUrl)
+}
+
+func (t *MirrorTable) GetBroken() ([]Mirror, error) {
+	query := `SELECT id, url, item_url, broken, error, checked_at FROM mirrors WHERE broken = 1`
+	return t.query(query)
+}
+
+func (t *MirrorTable) GetAll() ([]Mirror, error) {
+	query := `SELECT id, url, item_url, broken, error, checked_at FROM mirrors`
+	return t.query(query)
+}
+
+func (t *MirrorTable) query(query string, args ...interface{}) ([]Mirror, error) {
+	rows, err := t.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var mirrors []Mirror
+	for rows.Next() {
+		var mirror Mirror
+		err := rows.Scan(&mirror.ID, &mirror.Url, &mirror.ItemUrl, &mirror.Broken, &mirror.Error, &mirror.CheckedAt)
+		if err != nil {
+			return nil, err
+		}
+		mirrors = append(mirrors, mirror)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return mirrors, nil
+}
+
+func (t *MirrorTable) DebugPrintAll() error {
+	mirrors, err := t.GetAll()
+	if err != nil {
+		return err
+	}
+
+	for _, mirror := range mirrors {
+		fmt.Printf("ID: %d, URL: %s, Item URL: %s, Broken: %t, Error: %s, Checked At: %s\n",
+			mirror.ID, mirror.Url, mirror.ItemUrl, mirror.Broken, mirror.Error, mirror.CheckedAt)
+	}
+
+	return nil
+}
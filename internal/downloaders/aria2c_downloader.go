@@ -3,34 +3,29 @@ package downloaders
 import (
 	"internal/common"
 	"io"
+	"log"
 	"os"
-	"regexp"
+	"time"
 )
 
 type Aria2Downloader struct {
 	DownloaderConfig *common.DownloaderConfig
+	// a download is killed after Timeout, unless it is 0
+	Timeout time.Duration
+	// logs and the output of aria2 go to Logger, or the standard logger and stdout if nil
+	Logger *log.Logger
+	// a download takes a slot of the host of its URL, unless HostLimiter is nil
+	HostLimiter *common.HostLimiter
 }
 
 func (d *Aria2Downloader) Download(url string, path string) error {
-	l := common.NewLoggerWithPrefixAndColor("[Aria2Downloader.Download] ")
+	l := common.NewLoggerWithPrefixAndColorTo(d.Logger, "[Aria2Downloader.Download] ")
 	l.Printf("url: %s, path: %s", url, path)
 
 	// build arguments
-	args := append([]string{}, d.DownloaderConfig.DefaultArgs...)
-
-	// add customized args
-	for _, argConf := range d.DownloaderConfig.Args {
-		// TODO: Support more matcher types later.
-		regex, err := regexp.Compile(argConf.Matcher.Pattern)
-		if err != nil {
-			l.Printf("failed to compile regexp: `%s`, error: %v", argConf.Matcher.Pattern, err)
-			return err
-		}
-		if !regex.MatchString(url) {
-			continue
-		}
-		args = append(args, argConf.Args...)
-		l.Printf("matched pattern, adding args: %v", argConf.Args)
+	args, err := argsForUrl(l, d.DownloaderConfig, url)
+	if err != nil {
+		return err
 	}
 
 	// replace placeholders
@@ -45,11 +40,18 @@ func (d *Aria2Downloader) Download(url string, path string) error {
 
 	l.Printf("got args: %s", args)
 
+	release := d.HostLimiter.Acquire(url)
+	defer release()
+
 	// run commandline, and redirect output
 	cmdline := d.DownloaderConfig.Cmd
 	l.Printf("Run command: %s, %v", cmdline, args)
-	err := common.RunCmd(cmdline, args, func(stdout io.ReadCloser) {
-		io.Copy(os.Stdout, stdout)
+	logger, out := log.Default(), io.Writer(os.Stdout)
+	if d.Logger != nil {
+		logger, out = d.Logger, d.Logger.Writer()
+	}
+	err = common.RunCmdWithLogger(logger, "/", d.Timeout, cmdline, args, func(stdout io.ReadCloser) {
+		io.Copy(out, stdout)
 	})
 	if err != nil {
 		l.Printf("failed to execute command `%s`, error: %v", cmdline, err)
@@ -3,6 +3,9 @@ package downloaders
 import (
 	"fmt"
 	"internal/common"
+	"log"
+	"regexp"
+	"time"
 )
 
 type Downloader interface {
@@ -24,6 +27,13 @@ type DownloaderFactoryImpl struct {
 }
 
 func CreateDownloaderFactory(config *common.ServerConfig) DownloaderFactory {
+	return CreateItemDownloaderFactory(config, nil, nil)
+}
+
+// CreateItemDownloaderFactory is CreateDownloaderFactory for items downloaded in
+// parallel: its downloaders print their logs and the output of their commands to
+// logger, unless it is nil, and take a slot of hostLimiter for every connection.
+func CreateItemDownloaderFactory(config *common.ServerConfig, logger *log.Logger, hostLimiter *common.HostLimiter) DownloaderFactory {
 	downloaderConfigs := make(map[string]*common.DownloaderConfig)
 	for _, conf := range config.Downloaders {
 		downloaderConfigs[conf.Name] = &conf
@@ -32,7 +42,10 @@ func CreateDownloaderFactory(config *common.ServerConfig) DownloaderFactory {
 	return &DownloaderFactoryImpl{
 		Factories: map[string]func(*common.DownloaderConfig) Downloader{
 			"aria2": func(downloaderConfig *common.DownloaderConfig) Downloader {
-				return &Aria2Downloader{DownloaderConfig: downloaderConfig}
+				return &Aria2Downloader{DownloaderConfig: downloaderConfig, Timeout: time.Duration(config.Server.Timeout) * time.Second, Logger: logger, HostLimiter: hostLimiter}
+			},
+			common.BuiltinHttpDownloader: func(downloaderConfig *common.DownloaderConfig) Downloader {
+				return &HttpDownloader{DownloaderConfig: downloaderConfig, Timeout: time.Duration(config.Server.Timeout) * time.Second, Logger: logger, HostLimiter: hostLimiter}
 			},
 		},
 		DownloaderConfigs: downloaderConfigs,
@@ -45,3 +58,28 @@ func (f *DownloaderFactoryImpl) Create(name string) (Downloader, error) {
 	}
 	return nil, fmt.Errorf("downloader %s not found", name)
 }
+
+// argsForUrl returns the default args of a downloader, and the args of its matchers
+// which match url. config may be nil.
+func argsForUrl(l *common.LoggerWithPrefix, config *common.DownloaderConfig, url string) ([]string, error) {
+	if config == nil {
+		return nil, nil
+	}
+	args := append([]string{}, config.DefaultArgs...)
+
+	// add customized args
+	for _, argConf := range config.Args {
+		// TODO: Support more matcher types later.
+		regex, err := regexp.Compile(argConf.Matcher.Pattern)
+		if err != nil {
+			l.Printf("failed to compile regexp: `%s`, error: %v", argConf.Matcher.Pattern, err)
+			return nil, err
+		}
+		if !regex.MatchString(url) {
+			continue
+		}
+		args = append(args, argConf.Args...)
+		l.Printf("matched pattern, adding args: %v", argConf.Args)
+	}
+	return args, nil
+}
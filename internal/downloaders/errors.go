@@ -0,0 +1,49 @@
+package downloaders
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+var (
+	// ErrNotFound is wrapped by errors of downloads the server has no file for.
+	ErrNotFound = errors.New("not found")
+	// ErrServer is wrapped by errors of downloads the server failed to serve,
+	// which may work when tried again.
+	ErrServer = errors.New("server error")
+)
+
+// HttpError is a download answered with an HTTP status which is not a success.
+type HttpError struct {
+	Url        string
+	StatusCode int
+	Status     string
+}
+
+func (e *HttpError) Error() string {
+	return fmt.Sprintf("failed to download %s, HTTP status: %s", e.Url, e.Status)
+}
+
+// Unwrap returns ErrNotFound or ErrServer, so errors.Is tells them apart.
+func (e *HttpError) Unwrap() error {
+	switch {
+	case e.StatusCode == http.StatusNotFound || e.StatusCode == http.StatusGone:
+		return ErrNotFound
+	case e.StatusCode >= 500:
+		return ErrServer
+	}
+	return nil
+}
+
+// HashMismatchError is a downloaded file whose hash is not the expected one.
+type HashMismatchError struct {
+	Path      string
+	Algorithm string
+	Expected  string
+	Actual    string
+}
+
+func (e *HashMismatchError) Error() string {
+	return fmt.Sprintf("file `%s` %s hash does not match. Expected: %s, Actual: %s", e.Path, e.Algorithm, e.Expected, e.Actual)
+}
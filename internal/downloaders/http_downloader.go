@@ -0,0 +1,402 @@
+package downloaders
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"internal/common"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HttpDownloader downloads with net/http, so servers need no download tool. Large
+// files are downloaded in ranges at once, and failed tries resume the partial files.
+// It reads the aria2 options it knows from the args of its config, see httpOptions,
+// and ignores the others, so its args can be the same as aria2's.
+type HttpDownloader struct {
+	DownloaderConfig *common.DownloaderConfig // may be nil
+	// a download is canceled after Timeout, unless it is 0
+	Timeout time.Duration
+	// http.DefaultClient if nil, which follows redirects
+	Client *http.Client
+	// logs go to Logger, or the standard logger if nil
+	Logger *log.Logger
+	// every connection takes a slot of the host of the URL, unless HostLimiter is nil
+	HostLimiter *common.HostLimiter
+}
+
+// httpOptions are the aria2 options HttpDownloader knows.
+type httpOptions struct {
+	header       http.Header // --header="Name: value", once per header
+	user         string      // --http-user
+	password     string      // --http-passwd
+	split        int         // -s, --split: ranges downloaded at once
+	minSplitSize int64       // -k, --min-split-size: smallest range, e.g. `1M`
+	maxTries     int         // -m, --max-tries
+}
+
+func (d *HttpDownloader) Download(url string, path string) error {
+	l := common.NewLoggerWithPrefixAndColorTo(d.Logger, "[HttpDownloader.Download] ")
+	l.Printf("url: %s, path: %s", url, path)
+
+	args, err := argsForUrl(l, d.DownloaderConfig, url)
+	if err != nil {
+		return err
+	}
+	options, err := parseHttpOptions(args)
+	if err != nil {
+		l.Printf("invalid args %v, error: %v", args, err)
+		return err
+	}
+
+	ctx := context.Background()
+	if d.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.Timeout)
+		defer cancel()
+	}
+
+	for try := 1; ; try++ {
+		err = d.download(ctx, l, url, path, options)
+		if err == nil {
+			return nil
+		}
+		if try >= options.maxTries || ctx.Err() != nil || !retryable(err) {
+			l.Printf("failed to download %s after %d tries, error: %v", url, try, err)
+			// the partial files are only resumed by the tries of this download, as
+			// another URL may serve other content
+			RemovePartial(path)
+			return err
+		}
+		l.Printf("try %d/%d failed, resuming: %v", try, options.maxTries, err)
+	}
+}
+
+// retryable tells if a download which failed with err may work when tried again.
+func retryable(err error) bool {
+	var httpErr *HttpError
+	if errors.As(err, &httpErr) {
+		return errors.Is(err, ErrServer)
+	}
+	return true
+}
+
+// download downloads url to path in ranges at once if it is large enough and the
+// server serves ranges, else in one request. A partial file at path is resumed.
+func (d *HttpDownloader) download(ctx context.Context, l *common.LoggerWithPrefix, url string, path string, o *httpOptions) error {
+	if o.split > 1 && !common.FileExists(path) {
+		size, ranges, err := d.probe(ctx, url, o)
+		if err != nil {
+			return err
+		}
+		if ranges && size >= 2*o.minSplitSize {
+			return d.downloadSegments(ctx, l, url, path, o, size)
+		}
+	}
+	return d.downloadRange(ctx, url, path, o, 0, -1)
+}
+
+// probe asks for the first byte of url, and returns the size of the file, -1 if
+// unknown, and whether the server serves ranges of it.
+func (d *HttpDownloader) probe(ctx context.Context, url string, o *httpOptions) (int64, bool, error) {
+	req, err := d.newRequest(ctx, url, o)
+	if err != nil {
+		return -1, false, err
+	}
+	req.Header.Set("Range", "bytes=0-0")
+	release := d.HostLimiter.Acquire(url)
+	defer release()
+	resp, err := d.client().Do(req)
+	if err != nil {
+		return -1, false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		_, total, ok := parseContentRange(resp.Header.Get("Content-Range"))
+		if !ok || total < 0 {
+			return -1, false, nil
+		}
+		return total, true, nil
+	case http.StatusOK:
+		return resp.ContentLength, false, nil
+	}
+	return -1, false, newHttpError(url, resp)
+}
+
+// downloadSegments downloads the file of url, of size bytes, in ranges at once, each
+// into a part file next to path, see partFile, and joins them into path. The part files
+// of a failed try are resumed.
+func (d *HttpDownloader) downloadSegments(ctx context.Context, l *common.LoggerWithPrefix, url string, path string, o *httpOptions, size int64) error {
+	n := o.split
+	if int64(n) > size/o.minSplitSize {
+		n = int(size / o.minSplitSize)
+	}
+	l.Printf("downloading %s in %d ranges", common.PrettyPrintSize(size), n)
+
+	parts := make([]string, n)
+	errs := make([]error, n)
+	common.RunParallel(n, n, func(i int) {
+		parts[i] = partFile(path, i)
+		start := int64(i) * size / int64(n)
+		end := int64(i+1)*size/int64(n) - 1
+		errs[i] = d.downloadRange(ctx, url, parts[i], o, start, end)
+	})
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	for _, part := range parts {
+		if err := appendFile(file, part); err != nil {
+			os.Remove(path)
+			return fmt.Errorf("failed to join %s: %w", part, err)
+		}
+	}
+	for _, part := range parts {
+		os.Remove(part)
+	}
+	return nil
+}
+
+// downloadRange downloads the bytes start to end of url, or to its end if end is -1,
+// into path. The bytes path already has are not downloaded again.
+func (d *HttpDownloader) downloadRange(ctx context.Context, url string, path string, o *httpOptions, start int64, end int64) error {
+	var done int64
+	if info, err := os.Stat(path); err == nil {
+		done = info.Size()
+	}
+	from := start + done
+	if end >= 0 && from > end {
+		return nil
+	}
+
+	req, err := d.newRequest(ctx, url, o)
+	if err != nil {
+		return err
+	}
+	if from > 0 || end >= 0 {
+		if end >= 0 {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", from, end))
+		} else {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", from))
+		}
+	}
+	release := d.HostLimiter.Acquire(url)
+	defer release()
+	resp, err := d.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		if rangeStart, _, ok := parseContentRange(resp.Header.Get("Content-Range")); !ok || rangeStart != from {
+			return fmt.Errorf("server sent range `%s` of %s, asked for bytes %d-", resp.Header.Get("Content-Range"), url, from)
+		}
+	case http.StatusOK:
+		// the server ignored the range, and sent the whole file
+		if start > 0 || end >= 0 {
+			return fmt.Errorf("server does not serve ranges of %s anymore", url)
+		}
+		done = 0
+	case http.StatusRequestedRangeNotSatisfiable:
+		// a file left complete by an earlier try
+		if _, total, ok := parseContentRange(resp.Header.Get("Content-Range")); ok && end < 0 && total == from {
+			return nil
+		}
+		return newHttpError(url, resp)
+	default:
+		return newHttpError(url, resp)
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := file.Truncate(done); err != nil {
+		return err
+	}
+	if _, err := file.Seek(done, io.SeekStart); err != nil {
+		return err
+	}
+
+	written, err := io.Copy(file, resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to download %s after %d bytes: %w", url, done+written, err)
+	}
+	if resp.ContentLength >= 0 && written != resp.ContentLength {
+		return fmt.Errorf("failed to download %s, got %d of %d bytes: %w", url, written, resp.ContentLength, io.ErrUnexpectedEOF)
+	}
+	return nil
+}
+
+func (d *HttpDownloader) newRequest(ctx context.Context, url string, o *httpOptions) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range o.header {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+	if o.user != "" {
+		req.SetBasicAuth(o.user, o.password)
+	}
+	return req, nil
+}
+
+func (d *HttpDownloader) client() *http.Client {
+	if d.Client != nil {
+		return d.Client
+	}
+	return http.DefaultClient
+}
+
+func newHttpError(url string, resp *http.Response) *HttpError {
+	return &HttpError{Url: url, StatusCode: resp.StatusCode, Status: resp.Status}
+}
+
+// parseContentRange parses a Content-Range header, e.g. `bytes 0-99/1234`, or
+// `bytes */1234`. start is -1 for `*`, and total for an unknown size, e.g. `/*`.
+func parseContentRange(value string) (start int64, total int64, ok bool) {
+	value, found := strings.CutPrefix(value, "bytes ")
+	if !found {
+		return 0, 0, false
+	}
+	byteRange, size, found := strings.Cut(value, "/")
+	if !found {
+		return 0, 0, false
+	}
+
+	start, total = -1, -1
+	var err error
+	if byteRange != "*" {
+		first, _, _ := strings.Cut(byteRange, "-")
+		if start, err = strconv.ParseInt(first, 10, 64); err != nil {
+			return 0, 0, false
+		}
+	}
+	if size != "*" {
+		if total, err = strconv.ParseInt(size, 10, 64); err != nil {
+			return 0, 0, false
+		}
+	}
+	return start, total, true
+}
+
+// partFile returns the file the i-th range of a file downloaded to path goes to.
+func partFile(path string, i int) string {
+	return fmt.Sprintf("%s.part%d", path, i)
+}
+
+// RemovePartial removes the file a download to path left, and its part files.
+func RemovePartial(path string) {
+	os.Remove(path)
+	parts, _ := filepath.Glob(path + ".part*")
+	for _, part := range parts {
+		os.Remove(part)
+	}
+}
+
+func appendFile(file *os.File, path string) error {
+	part, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer part.Close()
+	_, err = io.Copy(file, part)
+	return err
+}
+
+// parseHttpOptions reads the options of HttpDownloader from aria2 args, e.g.
+// `--header=Authorization: Bearer xyz` or `-s 4`. Other args are ignored. The
+// defaults are aria2's.
+func parseHttpOptions(args []string) (*httpOptions, error) {
+	o := &httpOptions{
+		header:       http.Header{},
+		split:        5,
+		minSplitSize: 20 << 20,
+		maxTries:     5,
+	}
+
+	for i := 0; i < len(args); i++ {
+		name, value, hasValue := strings.Cut(args[i], "=")
+		if !strings.HasPrefix(name, "-") {
+			continue
+		}
+		switch name {
+		case "--header", "--http-user", "--http-passwd", "-s", "--split", "-k", "--min-split-size", "-m", "--max-tries":
+		default:
+			continue
+		}
+		if !hasValue {
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("`%s` needs a value", name)
+			}
+			i++
+			value = args[i]
+		}
+
+		var err error
+		switch name {
+		case "--header":
+			headerName, headerValue, found := strings.Cut(value, ":")
+			if !found {
+				return nil, fmt.Errorf("`%s` must be `Name: value`, got `%s`", name, value)
+			}
+			o.header.Add(strings.TrimSpace(headerName), strings.TrimSpace(headerValue))
+		case "--http-user":
+			o.user = value
+		case "--http-passwd":
+			o.password = value
+		case "-s", "--split":
+			o.split, err = strconv.Atoi(value)
+			if err == nil && o.split < 1 {
+				err = fmt.Errorf("must be at least 1")
+			}
+		case "-k", "--min-split-size":
+			o.minSplitSize, err = parseSize(value)
+			if err == nil && o.minSplitSize < 1 {
+				err = fmt.Errorf("must be at least 1")
+			}
+		case "-m", "--max-tries":
+			// unlike aria2's, 0 does not try forever, but once
+			o.maxTries, err = strconv.Atoi(value)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid value `%s` of `%s`: %w", value, name, err)
+		}
+	}
+	return o, nil
+}
+
+// parseSize parses a size like aria2, e.g. `1024`, `16K` or `1M`.
+func parseSize(value string) (int64, error) {
+	unit := int64(1)
+	switch {
+	case strings.HasSuffix(value, "K"):
+		unit, value = 1<<10, strings.TrimSuffix(value, "K")
+	case strings.HasSuffix(value, "M"):
+		unit, value = 1<<20, strings.TrimSuffix(value, "M")
+	}
+	size, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return size * unit, nil
+}
@@ -0,0 +1,222 @@
+package downloaders
+
+import (
+	"bytes"
+	"errors"
+	"internal/common"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testContent is larger than the ranges of the tests, so it is downloaded in 3 of them.
+var testContent = bytes.Repeat([]byte("0123456789abcdef"), 200000)
+
+func testDownloader(args ...string) *HttpDownloader {
+	d := &HttpDownloader{Timeout: 10 * time.Second}
+	if len(args) > 0 {
+		d.DownloaderConfig = &common.DownloaderConfig{Name: "http", DefaultArgs: args}
+	}
+	return d
+}
+
+func serveContent(w http.ResponseWriter, r *http.Request) {
+	http.ServeContent(w, r, "file", time.Time{}, bytes.NewReader(testContent))
+}
+
+func download(t *testing.T, d *HttpDownloader, url string, path string) {
+	t.Helper()
+	if err := d.Download(url, path); err != nil {
+		t.Fatalf("Download(%s) failed: %v", url, err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, testContent) {
+		t.Fatalf("downloaded %d bytes, which are not the content", len(got))
+	}
+	if parts, _ := filepath.Glob(path + ".part*"); len(parts) > 0 {
+		t.Errorf("part files left: %v", parts)
+	}
+}
+
+func TestHttpDownloaderRanges(t *testing.T) {
+	var ranges atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" {
+			ranges.Add(1)
+		}
+		serveContent(w, r)
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "file")
+	download(t, testDownloader("--split=4", "--min-split-size=1M"), server.URL, path)
+	// the probe, and 3 ranges of at least 1M
+	if got := ranges.Load(); got != 4 {
+		t.Errorf("got %d range requests, want 4", got)
+	}
+}
+
+func TestHttpDownloaderWithoutRanges(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(testContent)
+	}))
+	defer server.Close()
+
+	// a partial file is downloaded again, as it can't be resumed
+	path := filepath.Join(t.TempDir(), "file")
+	os.WriteFile(path, []byte("partial"), 0644)
+	download(t, testDownloader("--split=4", "--min-split-size=1M"), server.URL, path)
+}
+
+func TestHttpDownloaderResumesTruncatedBody(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			// the connection breaks in the middle of the file
+			w.Header().Set("Content-Length", strconv.Itoa(len(testContent)))
+			w.Write(testContent[:1000])
+			return
+		}
+		if r.Header.Get("Range") != "bytes=1000-" {
+			t.Errorf("got range `%s`, want bytes=1000-", r.Header.Get("Range"))
+		}
+		serveContent(w, r)
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "file")
+	download(t, testDownloader("--split=1"), server.URL, path)
+	if got := requests.Load(); got != 2 {
+		t.Errorf("got %d requests, want 2", got)
+	}
+}
+
+func TestHttpDownloaderCompleteFile(t *testing.T) {
+	var status atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rw := &statusRecorder{ResponseWriter: w}
+		serveContent(rw, r)
+		status.Store(int32(rw.status))
+	}))
+	defer server.Close()
+
+	// a file left complete by an earlier try is answered with 416
+	path := filepath.Join(t.TempDir(), "file")
+	os.WriteFile(path, testContent, 0644)
+	download(t, testDownloader(), server.URL, path)
+	if got := status.Load(); got != http.StatusRequestedRangeNotSatisfiable {
+		t.Errorf("got status %d, want 416", got)
+	}
+}
+
+func TestHttpDownloaderErrors(t *testing.T) {
+	var broken atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/missing", http.NotFound)
+	mux.HandleFunc("/forbidden", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+	})
+	mux.HandleFunc("/broken", func(w http.ResponseWriter, r *http.Request) {
+		broken.Add(1)
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	d := testDownloader("--max-tries=3")
+	dir := t.TempDir()
+
+	err := d.Download(server.URL+"/missing", filepath.Join(dir, "missing"))
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("got %v, want ErrNotFound", err)
+	}
+
+	err = d.Download(server.URL+"/forbidden", filepath.Join(dir, "forbidden"))
+	var httpErr *HttpError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusForbidden || errors.Is(err, ErrServer) {
+		t.Errorf("got %v, want HttpError 403", err)
+	}
+
+	err = d.Download(server.URL+"/broken", filepath.Join(dir, "broken"))
+	if !errors.Is(err, ErrServer) {
+		t.Errorf("got %v, want ErrServer", err)
+	}
+	if got := broken.Load(); got != 3 {
+		t.Errorf("server errors were tried %d times, want 3", got)
+	}
+}
+
+func TestParseHttpOptions(t *testing.T) {
+	o, err := parseHttpOptions([]string{
+		"-x", "6", "-s", "4", "--min-split-size=16K", "-o", "$out", "$url",
+		"--header=Authorization: Bearer xyz", "--header", "X-Token: 1",
+		"--http-user=user", "--http-passwd", "secret", "-m", "2",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.split != 4 || o.minSplitSize != 16<<10 || o.maxTries != 2 {
+		t.Errorf("got split %d, min split size %d, max tries %d", o.split, o.minSplitSize, o.maxTries)
+	}
+	if o.header.Get("Authorization") != "Bearer xyz" || o.header.Get("X-Token") != "1" {
+		t.Errorf("got headers %v", o.header)
+	}
+	if o.user != "user" || o.password != "secret" {
+		t.Errorf("got user %s, password %s", o.user, o.password)
+	}
+
+	for _, args := range [][]string{{"-s"}, {"-s", "0"}, {"--header=no colon"}, {"--min-split-size=1G"}} {
+		if _, err := parseHttpOptions(args); err == nil {
+			t.Errorf("parseHttpOptions(%v) did not fail", args)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for value, want := range map[string]int64{"1024": 1024, "16K": 16 << 10, "1M": 1 << 20} {
+		if got, err := parseSize(value); err != nil || got != want {
+			t.Errorf("parseSize(%s) = %d, %v, want %d", value, got, err, want)
+		}
+	}
+	if _, err := parseSize("M"); err == nil {
+		t.Errorf("parseSize(M) did not fail")
+	}
+}
+
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		value        string
+		start, total int64
+		ok           bool
+	}{
+		{"bytes 0-99/1234", 0, 1234, true},
+		{"bytes 100-199/*", 100, -1, true},
+		{"bytes */1234", -1, 1234, true},
+		{"0-99/1234", 0, 0, false},
+		{"bytes 0-99", 0, 0, false},
+	}
+	for _, tt := range tests {
+		start, total, ok := parseContentRange(tt.value)
+		if start != tt.start || total != tt.total || ok != tt.ok {
+			t.Errorf("parseContentRange(%s) = %d, %d, %t, want %d, %d, %t", tt.value, start, total, ok, tt.start, tt.total, tt.ok)
+		}
+	}
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
@@ -6,4 +6,9 @@ replace internal/common => ../../internal/common
 
 replace internal/db => ../../internal/db
 
-require internal/common v1.0.0
+require (
+	internal/common v1.0.0
+	internal/db v1.0.0
+)
+
+require github.com/mattn/go-sqlite3 v1.14.27 // indirect
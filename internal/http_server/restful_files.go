@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"internal/common"
+	"internal/db"
 	"net/http"
 	"os"
 	"path"
@@ -17,13 +18,25 @@ type FileInfo struct {
 }
 
 // getAllFilesHandler handles GET requests to /restapi/v1/allfiles
-func getAllFilesHandler(w http.ResponseWriter, r *http.Request, config *common.ServerConfig) {
+func getAllFilesHandler(w http.ResponseWriter, r *http.Request, config *common.ServerConfig, index *db.CacheIndexTable) {
 	l := common.NewLoggerWithPrefixAndColor("restful_server.getAllFilesHandler: ")
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
+	if index != nil {
+		fileInfos, err := indexedFiles(index)
+		if err != nil {
+			l.Printf("Error querying cache index: %v", err)
+			http.Error(w, fmt.Sprintf("Error querying cache index: %v", err), http.StatusInternalServerError)
+			return
+		}
+		l.Printf("Found %d files in cache index", len(fileInfos))
+		writeFileInfos(w, fileInfos)
+		return
+	}
+
 	dirPath := path.Join(config.Server.Workdir, "data")
 	// Verify the directory exists
 	info, err := os.Stat(dirPath)
@@ -63,6 +76,33 @@ func getAllFilesHandler(w http.ResponseWriter, r *http.Request, config *common.S
 	}
 
 	l.Printf("Found %d files in directory %s", len(fileInfos), dirPath)
+	writeFileInfos(w, fileInfos)
+}
+
+// indexedFiles returns the files of the repository cache known to index: the
+// file of each entry, and its empty id files.
+func indexedFiles(index *db.CacheIndexTable) ([]FileInfo, error) {
+	entries, err := index.GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	fileInfos := make([]FileInfo, 0, len(entries))
+	for i, entry := range entries {
+		dir := path.Join("content_addressable", entry.Algorithm, entry.Hash)
+		// entries are sorted, so the ids of a file follow each other
+		if i == 0 || entries[i-1].Algorithm != entry.Algorithm || entries[i-1].Hash != entry.Hash {
+			fileInfos = append(fileInfos, FileInfo{Name: path.Join(dir, "file"), Size: entry.Size})
+		}
+		if entry.IdHash != "" {
+			fileInfos = append(fileInfos, FileInfo{Name: path.Join(dir, "id-"+entry.IdHash), Size: 0})
+		}
+	}
+	return fileInfos, nil
+}
+
+func writeFileInfos(w http.ResponseWriter, fileInfos []FileInfo) {
+	l := common.NewLoggerWithPrefixAndColor("restful_server.writeFileInfos: ")
 	w.Header().Set("Content-Type", "application/json")
 
 	l.Printf("Sending %d files in response", len(fileInfos))
@@ -71,8 +111,8 @@ func getAllFilesHandler(w http.ResponseWriter, r *http.Request, config *common.S
 	}
 }
 
-func serveApiV1GetAllFiles(config *common.ServerConfig) http.HandlerFunc {
+func serveApiV1GetAllFiles(config *common.ServerConfig, index *db.CacheIndexTable) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		getAllFilesHandler(w, r, config)
+		getAllFilesHandler(w, r, config, index)
 	}
 }
@@ -1,9 +1,11 @@
 package httpserver
 
 import (
-	"fmt"
 	"internal/common"
+	"internal/db"
+	"net"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 )
@@ -25,8 +27,10 @@ func (b *HttpServerBuilder) ServeFiles() *HttpServerBuilder {
 	return b
 }
 
-func (b *HttpServerBuilder) ServeApiV1Files() *HttpServerBuilder {
-	b.serveMux.HandleFunc("/restapi/v1/files", serveApiV1GetAllFiles(b.config))
+// ServeApiV1Files serves the list of files of the repository cache, from index,
+// or by walking the cache if index is nil.
+func (b *HttpServerBuilder) ServeApiV1Files(index *db.CacheIndexTable) *HttpServerBuilder {
+	b.serveMux.HandleFunc("/restapi/v1/files", serveApiV1GetAllFiles(b.config, index))
 	return b
 }
 
@@ -39,9 +43,11 @@ func (b *HttpServerBuilder) ServeApiV1BazelCommands(bazelCommands *[][]string, m
 	return b
 }
 
+// Build returns the server listening on the host and port of the config, on all
+// interfaces if the host is empty.
 func (b *HttpServerBuilder) Build() *http.Server {
 	return &http.Server{
-		Addr:           fmt.Sprintf(":%d", b.config.Server.Port),
+		Addr:           net.JoinHostPort(b.config.Server.Host, strconv.Itoa(b.config.Server.Port)),
 		Handler:        b.serveMux,
 		IdleTimeout:    600 * time.Second, // b.config.Server.IdleTimeout,
 		ReadTimeout:    600 * time.Second, // b.config.Server.ReadTimeout,
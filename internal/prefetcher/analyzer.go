@@ -1,136 +1,360 @@
 package prefetcher
 
 import (
-	"crypto/sha256"
 	"fmt"
 	"log"
 	"os"
-	"path"
-	"path/filepath"
 
 	"internal/common"
 )
 
-func AnalyzePrefetchItems(prefetchers []PrefetchMatchers, cacheDir string) ([]*PrefetchItem, error) {
-	items := make([]*PrefetchItem, 0, len(prefetchers))
-	for _, i := range prefetchers {
-		item, err := analyzePrefetchItem(&i, cacheDir)
-		if err == os.ErrExist {
-			log.Printf("Item %s exists in bazel cache.", i.Name)
-		} else if err != nil {
-			log.Printf("Failed to analyze item %s", i.Name)
-		} else {
-			log.Printf("Got item: %+v", item)
-			items = append(items, item)
+// CacheStatus tells whether bazel's repository cache has an item.
+type CacheStatus string
+
+const (
+	CacheStatusMissing CacheStatus = "missing"
+	CacheStatusCached  CacheStatus = "cached"
+	// the cache could not be checked, or the item is invalid
+	CacheStatusUnknown CacheStatus = "unknown"
+)
+
+// AnalyzedItem is an item found by a prefetcher. Error of the item is set if it is invalid.
+type AnalyzedItem struct {
+	*PrefetchItem
+	Prefetcher  string
+	CacheStatus CacheStatus
+}
+
+// FailedPrefetcher is a prefetcher whose matchers failed.
+type FailedPrefetcher struct {
+	Name string
+	Err  error
+}
+
+// Analysis is the result of AnalyzeAll.
+type Analysis struct {
+	Items  []*AnalyzedItem
+	Failed []*FailedPrefetcher
+}
+
+// HasErrors tells if a prefetcher failed or found an invalid item.
+func (a *Analysis) HasErrors() bool {
+	if len(a.Failed) > 0 {
+		return true
+	}
+	for _, item := range a.Items {
+		if item.Error != nil {
+			return true
 		}
 	}
+	return false
+}
+
+// AnalyzePrefetchItems returns the valid items of the prefetchers, which are not
+// in bazel's cache at cacheDir yet.
+func AnalyzePrefetchItems(prefetchers []PrefetchMatchers, cacheDir string) ([]*PrefetchItem, error) {
+	return AnalyzePrefetchItemsWithIndex(prefetchers, NewDirCacheIndex(cacheDir), 1)
+}
 
+// AnalyzePrefetchItemsWithIndex is AnalyzePrefetchItems, looking items up in index,
+// and running up to workers prefetchers at once.
+func AnalyzePrefetchItemsWithIndex(prefetchers []PrefetchMatchers, index CacheIndex, workers int) ([]*PrefetchItem, error) {
+	analysis := AnalyzeAllWithIndex(prefetchers, index, workers)
+
+	items := make([]*PrefetchItem, 0, len(analysis.Items))
+	for _, item := range analysis.Items {
+		if item.Error == nil && item.CacheStatus == CacheStatusMissing {
+			items = append(items, item.PrefetchItem)
+		}
+	}
 	return items, nil
 }
 
-func analyzePrefetchItem(info *PrefetchMatchers, cacheDir string) (*PrefetchItem, error) {
-	item, err := getDownloadUrlAndHash(info)
+// AnalyzeAll runs every prefetcher, and returns all items found, with whether
+// they are in bazel's cache, and the prefetchers which failed. It downloads nothing
+// but what matchers read, e.g. checksum files.
+func AnalyzeAll(prefetchers []PrefetchMatchers, cacheDir string) *Analysis {
+	return AnalyzeAllWithIndex(prefetchers, NewDirCacheIndex(cacheDir), 1)
+}
+
+// AnalyzeAllWithIndex is AnalyzeAll, looking items up in index, and running up to
+// workers prefetchers at once. The result is in the order of the prefetchers.
+func AnalyzeAllWithIndex(prefetchers []PrefetchMatchers, index CacheIndex, workers int) *Analysis {
+	// the logs of every prefetcher are printed in the order of the prefetchers,
+	// whatever order they finish in
+	logs := common.NewOrderedLogs(len(prefetchers))
+	found := make([][]*AnalyzedItem, len(prefetchers))
+	errs := make([]error, len(prefetchers))
+	common.RunParallel(len(prefetchers), workers, func(i int) {
+		defer logs.Done(i)
+		l := logs.Logger(i)
+		common.LogSeparatorTo(l, prefetchers[i].Name)
+		found[i], errs[i] = analyzePrefetchItems(l, &prefetchers[i], index)
+	})
+
+	analysis := &Analysis{}
+	for i, info := range prefetchers {
+		if errs[i] != nil {
+			log.Printf("Failed to analyze item %s", info.Name)
+			analysis.Failed = append(analysis.Failed, &FailedPrefetcher{Name: info.Name, Err: errs[i]})
+			continue
+		}
+		log.Printf("Analyzed item %s: %d items found", info.Name, len(found[i]))
+		analysis.Items = append(analysis.Items, found[i]...)
+	}
+
+	return analysis
+}
+
+func analyzePrefetchItems(logger *log.Logger, info *PrefetchMatchers, index CacheIndex) ([]*AnalyzedItem, error) {
+	candidates, err := getPrefetchItems(logger, info)
 	if err != nil {
-		log.Printf("Failed to get download URL and hash for item %s: %v", info.Name, err)
+		logger.Printf("Failed to get download URL and hash for item %s: %v", info.Name, err)
 		return nil, err
 	}
 
-	err = checkIfExistsInBazelCache(item, cacheDir)
-	if err != nil {
+	items := make([]*AnalyzedItem, 0, len(candidates))
+	for _, item := range candidates {
+		analyzed := &AnalyzedItem{PrefetchItem: item, Prefetcher: info.Name, CacheStatus: CacheStatusUnknown}
+		items = append(items, analyzed)
+
+		if err := normalizeHash(item); err != nil {
+			logger.Printf("Invalid hash of item %s (%s), skipping: %v", item.Name, item.Url, err)
+			item.Error = fmt.Errorf("invalid hash: %w", err)
+			continue
+		}
+		err = checkIfExistsInBazelCache(logger, item, index)
 		if err == os.ErrExist {
-			log.Printf("The item %s already exists in bazel cache.", info.Name)
+			logger.Printf("Item %s exists in bazel cache.", item.Name)
+			analyzed.CacheStatus = CacheStatusCached
+		} else if err != nil {
+			logger.Printf("Failed to check if item %s exists in bazel cache: %v", item.Name, err)
 		} else {
-			log.Printf("Failed to check if item %s exists in bazel cache: %v", info.Name, err)
+			logger.Printf("Got item: %+v", item)
+			analyzed.CacheStatus = CacheStatusMissing
 		}
-		return nil, err
 	}
-	return item, nil
+	return items, nil
 }
 
-func checkIfExistsInBazelCache(item *PrefetchItem, cacheDir string) error {
-	l := common.NewLoggerWithPrefixAndColor("prefetcher: ")
-	l.Printf("Checking if item %s exists in bazel cache...", item.Url)
-
-	hashOfUrl := fmt.Sprintf("%x", sha256.Sum256([]byte(item.Url)))
-	l.Printf("item: %s, %s, %s, %s", item.Path, item.Hash, item.Url, hashOfUrl)
-	cacheDirInside := path.Join(cacheDir, "content_addressable", "sha256")
+// normalizeHash turns the hash of an item, which matchers return as written in
+// the source (hex, `sha384-<base64>`, ...), into a lowercase hex digest and its
+// algorithm. Items without a hash are downloaded and hashed with sha256.
+func normalizeHash(item *PrefetchItem) error {
 	if item.Hash == "" {
-		// just try to find the id file exist
-		hashFilename := fmt.Sprintf("id-%s", hashOfUrl)
-		found, parentDir, err := findFileAndReturnParent(cacheDirInside, hashFilename)
-		if err != nil {
-			return err
-		}
-		if !found {
-			return nil
-		} else if common.FileExists(path.Join(parentDir, "file")) {
-			return os.ErrExist
-		} else {
-			return nil
-		}
+		item.HashType = common.DefaultHashAlgorithm
+		return nil
 	}
+	checksum, err := common.ParseChecksum(item.Hash)
+	if err != nil {
+		return err
+	}
+	item.Hash = checksum.Hex
+	item.HashType = checksum.Algorithm
+	return nil
+}
 
-	outerDir := path.Join(cacheDirInside, item.Hash)
-	innerFile := path.Join(outerDir, "file")
-	hashFile := path.Join(outerDir, fmt.Sprintf("id-%s", hashOfUrl))
+// getPrefetchItems returns the items found by the matchers of one prefetch config entry.
+func getPrefetchItems(logger *log.Logger, info *PrefetchMatchers) ([]*PrefetchItem, error) {
+	combinations, err := resolveParams(logger, info)
+	if err != nil {
+		return nil, err
+	}
 
-	if !common.FileExists(innerFile) {
-		l.Printf("inner file %s does not exist", innerFile)
-		return nil
+	items := make([]*PrefetchItem, 0)
+	for _, vars := range combinations {
+		if len(vars) > 0 {
+			logger.Printf("params of package `%s`: %v", info.Name, vars)
+		}
+		found, err := getPrefetchItemsWithVars(logger, info, vars)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, found...)
 	}
+	return items, nil
+}
 
-	if !common.FileExists(hashFile) {
-		log.Printf("hash file %s does not exist", hashFile)
-		return nil
+func getPrefetchItemsWithVars(logger *log.Logger, info *PrefetchMatchers, vars Vars) ([]*PrefetchItem, error) {
+	if m, ok := info.UrlMatcher.(PrefetchItemMatcher); ok {
+		items, err := m.MatchItems(logger, vars)
+		if err != nil {
+			logger.Printf("error when trying to find items of package %s, err: %v", info.Name, err)
+			return nil, err
+		}
+		for _, item := range items {
+			if item.Name == "" {
+				item.Name = info.Name
+			}
+		}
+		return items, nil
 	}
 
-	log.Printf("Item %s found in cache.", item.Url)
-	return os.ErrExist
+	return getDownloadUrlsAndHashes(logger, info, vars)
 }
 
-func findFileAndReturnParent(root string, filename string) (bool, string, error) {
-	var found bool
-	var parentDir string
+// resolveParams runs the param matchers of an item in order, each one seeing the
+// preset vars and the params before it. A param with several distinct values
+// makes one set of vars for each of them, so the result is every combination
+// of the values.
+func resolveParams(logger *log.Logger, info *PrefetchMatchers) ([]Vars, error) {
+	combinations := []Vars{info.Vars}
+	for _, param := range info.Params {
+		next := make([]Vars, 0, len(combinations))
+		for _, vars := range combinations {
+			results, err := param.Matcher.Match(logger, vars)
+			if err != nil {
+				logger.Printf("error when trying to find param %s of package %s, err: %v", param.Name, info.Name, err)
+				return nil, err
+			}
+			seen := make(map[string]bool)
+			for _, result := range results {
+				if seen[result.Value] {
+					continue
+				}
+				seen[result.Value] = true
+				next = append(next, vars.with(param.Name, result.Value))
+			}
+		}
+		if len(next) == 0 {
+			logger.Printf("param %s of package `%s` not found in src.", param.Name, info.Name)
+			return nil, os.ErrNotExist
+		}
+		combinations = next
+	}
+	return combinations, nil
+}
 
-	err := filepath.WalkDir(root, func(currentPath string, d os.DirEntry, err error) error {
+func checkIfExistsInBazelCache(logger *log.Logger, item *PrefetchItem, index CacheIndex) error {
+	l := common.NewLoggerWithPrefixAndColorTo(logger, "prefetcher: ")
+	l.Printf("Checking if item %s exists in bazel cache...", item.Url)
+
+	// bazel only uses an entry if it was downloaded for the same canonical id
+	idHash, err := item.Checksum().IdHash(item.BazelCanonicalId())
+	if err != nil {
+		return err
+	}
+	l.Printf("item: %s, %s, %s, id-%s", item.Path, item.Hash, item.BazelCanonicalId(), idHash)
+	if item.Hash == "" {
+		// just try to find the id file
+		hashes, err := index.FindByIdHash(item.Checksum().Algorithm, idHash)
 		if err != nil {
 			return err
 		}
-
-		if !d.IsDir() && d.Name() == filename {
-			found = true
-			parentDir = path.Dir(currentPath)
-			return filepath.SkipDir
+		if len(hashes) == 0 {
+			return nil
 		}
+		logger.Printf("Item %s found in cache as %s.", item.Url, hashes[0])
+		return os.ErrExist
+	}
+
+	found, err := index.Has(item.Checksum().Algorithm, item.Hash, idHash)
+	if err != nil {
+		return err
+	}
+	if !found {
+		l.Printf("item %s with id-%s is not in cache", item.Checksum(), idHash)
 		return nil
-	})
+	}
 
-	return found, parentDir, err
+	logger.Printf("Item %s found in cache.", item.Url)
+	return os.ErrExist
 }
 
-func getDownloadUrlAndHash(item *PrefetchMatchers) (*PrefetchItem, error) {
-	matched, url, err := item.UrlMatcher.Match()
+// getDownloadUrlsAndHashes runs the URL and the hash matcher of an item, and pairs
+// every URL found with its own hash.
+func getDownloadUrlsAndHashes(logger *log.Logger, item *PrefetchMatchers, vars Vars) ([]*PrefetchItem, error) {
+	urls, err := item.UrlMatcher.Match(logger, vars)
 	if err != nil {
-		log.Printf("error when trying to find url package %s, err: %v", item.Name, err)
+		logger.Printf("error when trying to find url package %s, err: %v", item.Name, err)
 		return nil, err
 	}
-	if !matched {
-		log.Printf("url of package `%s` not found in src.", item.Name)
+	if len(urls) == 0 {
+		logger.Printf("url of package `%s` not found in src.", item.Name)
 		return nil, os.ErrNotExist
 	}
 
-	matched, hash, err := item.HashMatcher.Match()
+	if m, ok := item.HashMatcher.(PrefetchHashMatcher); ok {
+		return getDownloadUrlsAndTheirHashes(logger, item, m, urls, vars)
+	}
+
+	hashes, err := item.HashMatcher.Match(logger, vars)
 	if err != nil {
-		log.Printf("error when trying to find hash for package %s, err: %v", item.Name, err)
+		logger.Printf("error when trying to find hash for package %s, err: %v", item.Name, err)
 		return nil, err
 	}
-	if !matched {
-		log.Printf("hash of package `%s` not found in src.", item.Name)
+	if len(hashes) == 0 {
+		logger.Printf("hash of package `%s` not found in src.", item.Name)
+		return nil, os.ErrNotExist
+	}
+
+	pairs := pairUrlsAndHashes(urls, hashes)
+	items := make([]*PrefetchItem, 0, len(urls))
+	seen := make(map[string]bool)
+	for i, url := range urls {
+		hash := pairs[i]
+		if len(urls) == 1 && (hash == nil || hash.Value == "") {
+			// a single URL keeps the old behavior: the first hash found
+			hash = hashes[0]
+		} else if hash == nil {
+			logger.Printf("cannot tell which hash belongs to url %s (%s:%d) of package `%s`, skipping.", url.Value, url.File, url.Line, item.Name)
+			continue
+		}
+		if seen[url.Value] {
+			continue
+		}
+		seen[url.Value] = true
+
+		items = append(items, &PrefetchItem{
+			Name:   item.Name,
+			Url:    url.Value,
+			Hash:   hash.Value,
+			Source: sourceOf(url),
+		})
+	}
+
+	if len(items) == 0 {
+		logger.Printf("no url of package `%s` could be paired with a hash.", item.Name)
+		return nil, os.ErrNotExist
+	}
+	return items, nil
+}
+
+// getDownloadUrlsAndTheirHashes asks a PrefetchHashMatcher for the hash of every URL.
+func getDownloadUrlsAndTheirHashes(logger *log.Logger, item *PrefetchMatchers, m PrefetchHashMatcher, urls []*MatchResult, vars Vars) ([]*PrefetchItem, error) {
+	items := make([]*PrefetchItem, 0, len(urls))
+	seen := make(map[string]bool)
+	for _, url := range urls {
+		if seen[url.Value] {
+			continue
+		}
+		seen[url.Value] = true
+
+		hash, err := m.MatchHash(logger, url.Value, vars)
+		if err != nil {
+			logger.Printf("cannot find hash of url %s of package `%s`, skipping: %v", url.Value, item.Name, err)
+			continue
+		}
+
+		items = append(items, &PrefetchItem{
+			Name:   item.Name,
+			Url:    url.Value,
+			Hash:   hash.Value,
+			Source: sourceOf(url),
+		})
+	}
+
+	if len(items) == 0 {
+		logger.Printf("no hash found for any url of package `%s`.", item.Name)
 		return nil, os.ErrNotExist
 	}
+	return items, nil
+}
 
-	return &PrefetchItem{
-		Url:  url,
-		Hash: hash,
-	}, nil
+// sourceOf tells where a match was found, e.g. `third_party/deps.bzl:12`.
+func sourceOf(result *MatchResult) string {
+	if result.Line > 0 {
+		return fmt.Sprintf("%s:%d", result.File, result.Line)
+	}
+	return result.File
 }
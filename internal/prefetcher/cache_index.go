@@ -0,0 +1,63 @@
+package prefetcher
+
+import (
+	"internal/common"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// CacheIndex tells which files bazel's repository cache has. db.CacheIndexTable
+// implements it without walking the cache.
+type CacheIndex interface {
+	// Has tells if the cache has the file of hash, marked with the id file of idHash.
+	Has(algorithm string, hash string, idHash string) (bool, error)
+	// FindByIdHash returns the hashes of the files marked with the id file of idHash.
+	FindByIdHash(algorithm string, idHash string) ([]string, error)
+}
+
+// dirCacheIndex looks files up in the cache directory itself, for clients which
+// don't keep an index. FindByIdHash walks the whole cache.
+type dirCacheIndex struct {
+	cacheDir string
+}
+
+func NewDirCacheIndex(cacheDir string) CacheIndex {
+	return &dirCacheIndex{cacheDir: cacheDir}
+}
+
+func (d *dirCacheIndex) Has(algorithm string, hash string, idHash string) (bool, error) {
+	outerDir := (&common.Checksum{Algorithm: algorithm, Hex: hash}).CacheDir(d.cacheDir)
+	if !common.FileExists(path.Join(outerDir, "file")) {
+		return false, nil
+	}
+	return common.FileExists(path.Join(outerDir, "id-"+idHash)), nil
+}
+
+func (d *dirCacheIndex) FindByIdHash(algorithm string, idHash string) ([]string, error) {
+	found, parentDir, err := findFileAndReturnParent(path.Join(d.cacheDir, "content_addressable", algorithm), "id-"+idHash)
+	if err != nil || !found || !common.FileExists(path.Join(parentDir, "file")) {
+		return nil, err
+	}
+	return []string{path.Base(parentDir)}, nil
+}
+
+func findFileAndReturnParent(root string, filename string) (bool, string, error) {
+	var found bool
+	var parentDir string
+
+	err := filepath.WalkDir(root, func(currentPath string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !d.IsDir() && d.Name() == filename {
+			found = true
+			parentDir = path.Dir(currentPath)
+			return filepath.SkipDir
+		}
+		return nil
+	})
+
+	return found, parentDir, err
+}
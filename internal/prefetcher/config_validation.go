@@ -0,0 +1,130 @@
+package prefetcher
+
+import (
+	"internal/common"
+	"regexp"
+)
+
+var templateNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// required fields of the matcher types, besides the file of matchers which have a default one
+var requiredMatcherFields = map[string][]string{
+	"anchor":        {"file", "anchor_regex", "regex"},
+	"regex":         {"file", "regex"},
+	"block":         {"file", "anchor_regex", "regex"},
+	"structured":    {"file", "path"},
+	"starlark":      {"file"},
+	"exec":          {"cmd"},
+	"checksum_file": {"format"},
+	"hardcoded":     {"format"},
+}
+
+// ValidatePrefetchConfig returns every problem of the items of a prefetch config,
+// with the JSON path where it is, as common.ConfigErrors.
+func ValidatePrefetchConfig(prefetchConfig *common.PrefetchConfig) error {
+	var errs common.ConfigErrors
+	for i, pf := range prefetchConfig.Items {
+		path := common.ConfigIndexPath("items", i)
+		if pf == nil {
+			errs.Addf(path, "must be an object")
+			continue
+		}
+		if pf.Name == "" {
+			errs.Addf(common.ConfigPath(path, "name"), "is required")
+		}
+
+		for _, key := range sortedKeys(pf.Matrix) {
+			if !templateNameRegex.MatchString(key) {
+				errs.Addf(common.ConfigPath(path, "matrix"), "`%s` is not a valid name for `{NAME}`", key)
+			}
+			if len(pf.Matrix[key]) == 0 {
+				errs.Addf(common.ConfigPath(common.ConfigPath(path, "matrix"), key), "has no values")
+			}
+		}
+
+		for j, param := range pf.Params {
+			paramPath := common.ConfigIndexPath(common.ConfigPath(path, "params"), j)
+			if !templateNameRegex.MatchString(param.Name) {
+				errs.Addf(common.ConfigPath(paramPath, "name"), "`%s` is not a valid name for `{NAME}`", param.Name)
+			}
+			validateMatcherConfig(paramPath, param.MatcherConfig, &errs)
+		}
+
+		if pf.UrlMatcherConfig.Type == "" {
+			errs.Addf(common.ConfigPath(path, "url_matcher"), "is required")
+		} else {
+			validateMatcherConfig(common.ConfigPath(path, "url_matcher"), pf.UrlMatcherConfig, &errs)
+		}
+		validateMatcherConfig(common.ConfigPath(path, "hash_matcher"), pf.HashMatcherConfig, &errs)
+		validateHardcodedHash(common.ConfigPath(path, "hash_matcher"), pf.HashMatcherConfig, &errs)
+	}
+	return errs.Err()
+}
+
+func validateMatcherConfig(path string, matcherConfig common.MatcherConfig, errs *common.ConfigErrors) {
+	if matcherConfig.Type == "" {
+		return
+	}
+	// unknown types and helpers
+	if _, err := NewPrefetchFactory().CreatePrefetchMatcher("", matcherConfig); err != nil {
+		errs.Addf(path, "%v", err)
+		return
+	}
+
+	values := map[string]bool{
+		"file":         len(matcherConfig.File) > 0,
+		"anchor_regex": matcherConfig.AnchorRegex != "",
+		"regex":        matcherConfig.Regex != "",
+		"path":         matcherConfig.Path != "",
+		"cmd":          len(matcherConfig.Cmd) > 0,
+		"format":       matcherConfig.Format != "",
+	}
+	for _, field := range requiredMatcherFields[matcherConfig.Type] {
+		if !values[field] {
+			errs.Addf(common.ConfigPath(path, field), "is required by %s matchers", matcherConfig.Type)
+		}
+	}
+
+	validateRegex(common.ConfigPath(path, "anchor_regex"), matcherConfig.AnchorRegex, errs)
+	validateRegex(common.ConfigPath(path, "regex"), matcherConfig.Regex, errs)
+
+	if matcherConfig.Path != "" && !templateRefRegex.MatchString(matcherConfig.Path) {
+		if _, err := parseDataPath(matcherConfig.Path); err != nil {
+			errs.Addf(common.ConfigPath(path, "path"), "%v", err)
+		}
+	}
+
+	if matcherConfig.Timeout < 0 {
+		errs.Addf(common.ConfigPath(path, "timeout"), "must not be negative, got %d", matcherConfig.Timeout)
+	}
+}
+
+func validateRegex(path string, regex string, errs *common.ConfigErrors) {
+	if regex == "" {
+		return
+	}
+	if _, err := regexp.Compile(withoutTemplateRefs(regex)); err != nil {
+		errs.Addf(path, "invalid regex: %v", err)
+	}
+}
+
+// validateHardcodedHash checks the hash of a hardcoded hash matcher, unless it is a template.
+func validateHardcodedHash(path string, matcherConfig common.MatcherConfig, errs *common.ConfigErrors) {
+	if matcherConfig.Type != "hardcoded" || matcherConfig.Format == "" || templateRefRegex.MatchString(matcherConfig.Format) {
+		return
+	}
+	if _, err := common.ParseChecksum(matcherConfig.Format); err != nil {
+		errs.Addf(common.ConfigPath(path, "format"), "invalid hash: %v", err)
+	}
+}
+
+// withoutTemplateRefs replaces the `{NAME}` references of a regex with a literal,
+// so it can be compiled before the values are known. `{2}` is left as a repetition.
+func withoutTemplateRefs(regex string) string {
+	return templateRefRegex.ReplaceAllStringFunc(regex, func(ref string) string {
+		if templateNameRegex.MatchString(templateRefRegex.FindStringSubmatch(ref)[1]) {
+			return "x"
+		}
+		return ref
+	})
+}
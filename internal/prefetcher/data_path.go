@@ -0,0 +1,263 @@
+package prefetcher
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// dataPathStep is one step of a path into structured data, see parseDataPath.
+type dataPathStep struct {
+	recursive bool   // `..`: the step applies at any depth
+	wildcard  bool   // `*`: every element or field
+	field     string // `.name` or `['name']`
+	index     *int   // `[i]`, negative counts from the end
+	filter    *dataPathFilter
+}
+
+// dataPathFilter is `[?(@.key == 'value')]`: the elements of a list with key equal to value.
+type dataPathFilter struct {
+	key   string
+	value string
+}
+
+// dataNode is a value found by a path, with the normalized path to it, e.g. `$.tools[2].url`.
+type dataNode struct {
+	value interface{}
+	path  string
+}
+
+var dataPathFilterRegex = regexp.MustCompile(`^\?\(\s*@\.([\w-]+)\s*==\s*(?:'([^']*)'|"([^"]*)"|(\S+?))\s*\)$`)
+
+// parseDataPath parses the subset of JSONPath used by the structured matcher:
+// `$.a.b`, `$.a[0]`, `$.a[-1]`, `$.a[*]`, `$.a.*`, `$..b`, `$['a.b']` and
+// `$.a[?(@.name == 'x')]`. The leading `$` is optional.
+func parseDataPath(expr string) ([]dataPathStep, error) {
+	s := strings.TrimPrefix(strings.TrimSpace(expr), "$")
+	var steps []dataPathStep
+	for s != "" {
+		var step dataPathStep
+		switch {
+		case strings.HasPrefix(s, ".."):
+			step.recursive = true
+			s = s[2:]
+		case strings.HasPrefix(s, "."):
+			s = s[1:]
+		case strings.HasPrefix(s, "["):
+		default:
+			if len(steps) > 0 {
+				return nil, fmt.Errorf("invalid path `%s` at `%s`", expr, s)
+			}
+			// a path without `$.` starts with a field name
+		}
+
+		if strings.HasPrefix(s, "[") {
+			end := closingBracket(s)
+			if end < 0 {
+				return nil, fmt.Errorf("invalid path `%s`: unclosed `[`", expr)
+			}
+			if err := parseBracketStep(s[1:end], &step); err != nil {
+				return nil, fmt.Errorf("invalid path `%s`: %w", expr, err)
+			}
+			s = s[end+1:]
+		} else {
+			end := strings.IndexAny(s, ".[")
+			if end < 0 {
+				end = len(s)
+			}
+			name := s[:end]
+			if name == "" {
+				return nil, fmt.Errorf("invalid path `%s`: empty field name", expr)
+			}
+			if name == "*" {
+				step.wildcard = true
+			} else {
+				step.field = name
+			}
+			s = s[end:]
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+// closingBracket returns the index of the `]` closing the `[` at the start of s, skipping quoted strings.
+func closingBracket(s string) int {
+	var quote byte
+	for i := 1; i < len(s); i++ {
+		switch {
+		case quote != 0:
+			if s[i] == quote {
+				quote = 0
+			}
+		case s[i] == '\'' || s[i] == '"':
+			quote = s[i]
+		case s[i] == ']':
+			return i
+		}
+	}
+	return -1
+}
+
+func parseBracketStep(inside string, step *dataPathStep) error {
+	inside = strings.TrimSpace(inside)
+	switch {
+	case inside == "*":
+		step.wildcard = true
+	case len(inside) >= 2 && (inside[0] == '\'' || inside[0] == '"') && inside[len(inside)-1] == inside[0]:
+		step.field = inside[1 : len(inside)-1]
+	case strings.HasPrefix(inside, "?"):
+		match := dataPathFilterRegex.FindStringSubmatch(inside)
+		if match == nil {
+			return fmt.Errorf("unsupported filter `%s`", inside)
+		}
+		step.filter = &dataPathFilter{key: match[1], value: match[2] + match[3] + match[4]}
+	default:
+		index, err := strconv.Atoi(inside)
+		if err != nil {
+			return fmt.Errorf("unsupported index `%s`", inside)
+		}
+		step.index = &index
+	}
+	return nil
+}
+
+// evalDataPath returns the values found by steps in data, which is what
+// encoding/json, yaml.v3 or toml decode into an interface{}.
+func evalDataPath(data interface{}, steps []dataPathStep) []dataNode {
+	nodes := []dataNode{{value: data, path: "$"}}
+	for _, step := range steps {
+		var next []dataNode
+		for _, node := range nodes {
+			if step.recursive {
+				for _, descendant := range dataDescendants(node) {
+					next = append(next, applyDataPathStep(descendant, step)...)
+				}
+			} else {
+				next = append(next, applyDataPathStep(node, step)...)
+			}
+		}
+		nodes = next
+	}
+	return nodes
+}
+
+func applyDataPathStep(node dataNode, step dataPathStep) []dataNode {
+	var result []dataNode
+	switch v := node.value.(type) {
+	case map[string]interface{}:
+		if step.wildcard {
+			for _, key := range sortedKeys(v) {
+				result = append(result, dataNode{value: v[key], path: dataFieldPath(node.path, key)})
+			}
+		} else if step.field != "" {
+			if child, ok := v[step.field]; ok {
+				result = append(result, dataNode{value: child, path: dataFieldPath(node.path, step.field)})
+			}
+		}
+	case []interface{}:
+		for i, child := range v {
+			switch {
+			case step.wildcard:
+			case step.index != nil:
+				if index := *step.index; i != index && i != len(v)+index {
+					continue
+				}
+			case step.filter != nil:
+				object, ok := child.(map[string]interface{})
+				if !ok || dataString(object[step.filter.key]) != step.filter.value {
+					continue
+				}
+			default:
+				continue
+			}
+			result = append(result, dataNode{value: child, path: fmt.Sprintf("%s[%d]", node.path, i)})
+		}
+	}
+	return result
+}
+
+// dataDescendants returns node and every value below it, parents first.
+func dataDescendants(node dataNode) []dataNode {
+	result := []dataNode{node}
+	switch v := node.value.(type) {
+	case map[string]interface{}:
+		for _, key := range sortedKeys(v) {
+			result = append(result, dataDescendants(dataNode{value: v[key], path: dataFieldPath(node.path, key)})...)
+		}
+	case []interface{}:
+		for i, child := range v {
+			result = append(result, dataDescendants(dataNode{value: child, path: fmt.Sprintf("%s[%d]", node.path, i)})...)
+		}
+	}
+	return result
+}
+
+var dataFieldNameRegex = regexp.MustCompile(`^[A-Za-z_][\w-]*$`)
+
+func dataFieldPath(parent string, key string) string {
+	if dataFieldNameRegex.MatchString(key) {
+		return parent + "." + key
+	}
+	return parent + "['" + key + "']"
+}
+
+// dataPathScopes returns the paths enclosing path, innermost first,
+// e.g. `$.tools[2]`, `$.tools` and `$` for `$.tools[2].url`.
+func dataPathScopes(file string, path string) []string {
+	var scopes []string
+	for i := len(path) - 1; i > 0; i-- {
+		if path[i] == '.' || path[i] == '[' {
+			// skip separators inside ['quoted keys']
+			if path[i] == '.' && strings.Count(path[:i], "['") > strings.Count(path[:i], "']") {
+				continue
+			}
+			scopes = append(scopes, file+":"+path[:i])
+		}
+	}
+	return scopes
+}
+
+// dataString converts a scalar to the string it is written as, and returns "" for other values.
+func dataString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case int, int64, uint64, bool:
+		return fmt.Sprint(v)
+	}
+	return ""
+}
+
+// normalizeData converts the maps decoded by yaml.v3 with non-string keys to map[string]interface{}.
+func normalizeData(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			v[key] = normalizeData(child)
+		}
+		return v
+	case map[interface{}]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, child := range v {
+			result[fmt.Sprint(key)] = normalizeData(child)
+		}
+		return result
+	case []interface{}:
+		for i, child := range v {
+			v[i] = normalizeData(child)
+		}
+		return v
+	case []map[string]interface{}:
+		// toml arrays of tables
+		result := make([]interface{}, len(v))
+		for i, child := range v {
+			result[i] = normalizeData(child)
+		}
+		return result
+	}
+	return value
+}
@@ -0,0 +1,77 @@
+package prefetcher
+
+import (
+	"fmt"
+	"internal/common"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"time"
+)
+
+// Downloader downloads a file for matchers which read remote files, e.g. checksum files.
+// downloaders.Downloader implements it, so the server can use its configured downloader.
+type Downloader interface {
+	Download(url string, path string) error
+}
+
+// httpDownloader is the Downloader used when none is configured.
+type httpDownloader struct{}
+
+func (d *httpDownloader) Download(url string, filePath string) error {
+	client := &http.Client{Timeout: 60 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to download %s, HTTP status: %s", url, resp.Status)
+	}
+
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(file, resp.Body)
+	return err
+}
+
+// hostLimitedDownloader is a Downloader waiting for the host of a URL to be below its limit.
+type hostLimitedDownloader struct {
+	downloader Downloader
+	limiter    *common.HostLimiter
+}
+
+// NewHostLimitedDownloader returns downloader, limited to the downloads limiter allows
+// at once from each host.
+func NewHostLimitedDownloader(downloader Downloader, limiter *common.HostLimiter) Downloader {
+	if downloader == nil {
+		downloader = &httpDownloader{}
+	}
+	return &hostLimitedDownloader{downloader: downloader, limiter: limiter}
+}
+
+func (d *hostLimitedDownloader) Download(url string, filePath string) error {
+	release := d.limiter.Acquire(url)
+	defer release()
+	return d.downloader.Download(url, filePath)
+}
+
+// downloadContent downloads url with downloader and returns its content.
+func downloadContent(downloader Downloader, url string) ([]byte, error) {
+	// downloaders expect the file not to exist yet
+	dir, err := os.MkdirTemp("", "prefetcher_*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	file := path.Join(dir, "file")
+	if err := downloader.Download(url, file); err != nil {
+		return nil, err
+	}
+	return os.ReadFile(file)
+}
@@ -6,9 +6,13 @@ replace internal/common => ../../internal/common
 
 replace internal/db => ../../internal/db
 
-require internal/common v1.0.0
-
 require (
-	github.com/mattn/go-sqlite3 v1.14.27 // indirect
-	internal/db v1.0.0 // indirect
+	go.starlark.net v0.0.0-20231121155337-90ade8b19d09
+	internal/common v1.0.0
 )
+
+require gopkg.in/yaml.v3 v3.0.1
+
+require github.com/BurntSushi/toml v1.4.0
+
+require github.com/bmatcuk/doublestar/v4 v4.6.1
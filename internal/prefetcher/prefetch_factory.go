@@ -4,31 +4,134 @@ import (
 	"fmt"
 	"internal/common"
 	"path"
+	"strings"
+	"time"
 )
 
 type PrefetchFactory struct {
+	// downloads remote files read by matchers, e.g. checksum files
+	downloader Downloader
 }
 
 func NewPrefetchFactory() *PrefetchFactory {
-	return &PrefetchFactory{}
+	return NewPrefetchFactoryWithDownloader(nil)
+}
+
+// NewPrefetchFactoryWithDownloader returns a factory whose matchers download
+// remote files with downloader, or with plain HTTP if it is nil.
+func NewPrefetchFactoryWithDownloader(downloader Downloader) *PrefetchFactory {
+	if downloader == nil {
+		downloader = &httpDownloader{}
+	}
+	return &PrefetchFactory{downloader: downloader}
 }
 
 func (f *PrefetchFactory) CreatePrefetchMatcher(srcDir string, matcherConfig common.MatcherConfig) (PrefetchMatcher, error) {
+	for _, template := range append([]string{matcherConfig.Format, matcherConfig.Path}, matcherConfig.File...) {
+		if err := checkTemplate(template); err != nil {
+			return nil, err
+		}
+	}
+
+	// files returns a matcher running the one made by create on every file of
+	// the config, or on defaultFile if there are none.
+	files := func(defaultFile string, create func(file string) PrefetchMatcher) PrefetchMatcher {
+		patterns := []string(matcherConfig.File)
+		if len(patterns) == 0 && defaultFile != "" {
+			patterns = []string{defaultFile}
+		}
+		return newFilesMatcher(srcDir, patterns, create)
+	}
+
 	var result PrefetchMatcher
 	switch matcherConfig.Type {
 	case "anchor":
-		result = &PrefetchMatcherAnchor{
-			file:     path.Join(srcDir, matcherConfig.File),
-			anchor:   matcherConfig.AnchorRegex,
-			format:   matcherConfig.Format,
-			regexStr: matcherConfig.Regex,
-			maxLine:  matcherConfig.MaxLines,
-		}
+		result = files("", func(file string) PrefetchMatcher {
+			return &PrefetchMatcherAnchor{
+				file:     file,
+				anchor:   matcherConfig.AnchorRegex,
+				format:   matcherConfig.Format,
+				regexStr: matcherConfig.Regex,
+				maxLine:  matcherConfig.MaxLines,
+			}
+		})
 	case "regex":
-		result = &PrefetchMatcherRegex{
-			file:   path.Join(srcDir, matcherConfig.File),
-			regex:  matcherConfig.Regex,
-			format: matcherConfig.Format,
+		result = files("", func(file string) PrefetchMatcher {
+			return &PrefetchMatcherRegex{
+				file:   file,
+				regex:  matcherConfig.Regex,
+				format: matcherConfig.Format,
+			}
+		})
+	case "block":
+		result = files("", func(file string) PrefetchMatcher {
+			return &PrefetchMatcherBlock{
+				file:   file,
+				anchor: matcherConfig.AnchorRegex,
+				regex:  matcherConfig.Regex,
+				format: matcherConfig.Format,
+			}
+		})
+	case "structured":
+		result = files("", func(file string) PrefetchMatcher {
+			return &PrefetchMatcherStructured{
+				file:   file,
+				path:   matcherConfig.Path,
+				format: matcherConfig.Format,
+			}
+		})
+	case "starlark":
+		result = files("", func(file string) PrefetchMatcher {
+			return &PrefetchMatcherStarlark{file: file}
+		})
+	case "bzlmod_lockfile":
+		result = files(BzlmodLockfileName, f.newBzlmodLockfileMatcher())
+	case "resolved_file":
+		// the file is generated by cmd, so there is exactly one
+		file := ResolvedFileName
+		switch len(matcherConfig.File) {
+		case 0:
+		case 1:
+			file = matcherConfig.File[0]
+		default:
+			return nil, fmt.Errorf("resolved_file matcher takes a single file, got %v", matcherConfig.File)
+		}
+		result = &PrefetchMatcherResolvedFile{
+			srcDir: srcDir,
+			file:   path.Join(srcDir, file),
+			cmd:    matcherConfig.Cmd,
+		}
+	case "npm_lockfile":
+		result = files(PnpmLockfileName, func(file string) PrefetchMatcher {
+			return &PrefetchMatcherNpmLockfile{file: file, registry: matcherConfig.Registry}
+		})
+	case "cargo_lockfile":
+		result = files(CargoLockfileName, func(file string) PrefetchMatcher {
+			return &PrefetchMatcherCargoLockfile{file: file, registry: matcherConfig.Registry}
+		})
+	case "maven_install":
+		result = files(MavenInstallFileName, func(file string) PrefetchMatcher {
+			return &PrefetchMatcherMavenInstall{file: file}
+		})
+	case "requirements_lock":
+		result = files(RequirementsLockFileName, func(file string) PrefetchMatcher {
+			return &PrefetchMatcherRequirementsLock{file: file, index: matcherConfig.Registry, downloader: f.downloader}
+		})
+	case "exec":
+		timeout := DefaultExecTimeout
+		if matcherConfig.Timeout > 0 {
+			timeout = time.Duration(matcherConfig.Timeout) * time.Second
+		}
+		result = &PrefetchMatcherExec{
+			srcDir:  srcDir,
+			cmd:     matcherConfig.Cmd,
+			timeout: timeout,
+		}
+	case "checksum_file":
+		result = &PrefetchMatcherChecksumFile{
+			url:        matcherConfig.Format,
+			regex:      matcherConfig.Regex,
+			downloader: f.downloader,
 		}
 	case "hardcoded":
 		result = &PrefetchMatcherHardcoded{
@@ -44,8 +147,17 @@ func (f *PrefetchFactory) CreatePrefetchMatcher(srcDir string, matcherConfig com
 }
 
 func CreatePrefetchersFromConfig(srcDir string, prefetchConfig *common.PrefetchConfig) ([]PrefetchMatchers, error) {
+	return CreatePrefetchersFromConfigWithDownloader(srcDir, prefetchConfig, nil)
+}
+
+// CreatePrefetchersFromConfigWithDownloader is CreatePrefetchersFromConfig, whose
+// matchers download remote files with downloader.
+func CreatePrefetchersFromConfigWithDownloader(srcDir string, prefetchConfig *common.PrefetchConfig, downloader Downloader) ([]PrefetchMatchers, error) {
+	if err := ValidatePrefetchConfig(prefetchConfig); err != nil {
+		return nil, fmt.Errorf("invalid prefetch config: %w", err)
+	}
 
-	prefetchFactory := NewPrefetchFactory()
+	prefetchFactory := NewPrefetchFactoryWithDownloader(downloader)
 	prefetchers := make([]PrefetchMatchers, 0, len(prefetchConfig.Items))
 	for _, pf := range prefetchConfig.Items {
 		urlMatcher, err := prefetchFactory.CreatePrefetchMatcher(srcDir, pf.UrlMatcherConfig)
@@ -57,8 +169,75 @@ func CreatePrefetchersFromConfig(srcDir string, prefetchConfig *common.PrefetchC
 			return nil, err
 		}
 
-		prefetchers = append(prefetchers, PrefetchMatchers{Name: pf.Name, UrlMatcher: urlMatcher, HashMatcher: hashMatcher})
+		params := make([]PrefetchParam, 0, len(pf.Params))
+		for _, paramConfig := range pf.Params {
+			matcher, err := prefetchFactory.CreatePrefetchMatcher(srcDir, paramConfig.MatcherConfig)
+			if err != nil {
+				return nil, err
+			}
+			params = append(params, PrefetchParam{Name: paramConfig.Name, Matcher: matcher})
+		}
+
+		combinations, err := matrixCombinations(pf.Matrix)
+		if err != nil {
+			return nil, fmt.Errorf("invalid matrix of item %s: %w", pf.Name, err)
+		}
+		for _, vars := range combinations {
+			prefetchers = append(prefetchers, PrefetchMatchers{Name: matrixName(pf.Name, vars), Vars: vars, Params: params, UrlMatcher: urlMatcher, HashMatcher: hashMatcher})
+		}
+	}
+
+	// MODULE.bazel.lock at the root of the source tree is always analyzed, so
+	// bzlmod dependencies need no entries in prefetch.json.
+	if !prefetchConfig.DisableBzlmodLockfile {
+		prefetchers = append(prefetchers, PrefetchMatchers{
+			Name:        BzlmodLockfileName,
+			UrlMatcher:  newFilesMatcher(srcDir, []string{BzlmodLockfileName}, prefetchFactory.newBzlmodLockfileMatcher()),
+			HashMatcher: &PrefetchMatcherNil{},
+		})
 	}
 
 	return prefetchers, nil
 }
+
+// newBzlmodLockfileMatcher returns the function creating the matchers of lockfiles,
+// which share the source.json files they downloaded.
+func (f *PrefetchFactory) newBzlmodLockfileMatcher() func(file string) PrefetchMatcher {
+	sources := &sourceJsonCache{}
+	return func(file string) PrefetchMatcher {
+		return &PrefetchMatcherBzlmodLockfile{file: file, downloader: f.downloader, sources: sources}
+	}
+}
+
+// matrixCombinations returns every combination of the values of a matrix, in
+// the order of its sorted keys and of the values. It returns a single empty
+// combination for an empty matrix.
+func matrixCombinations(matrix map[string][]string) ([]Vars, error) {
+	combinations := []Vars{{}}
+	for _, key := range sortedKeys(matrix) {
+		values := matrix[key]
+		if len(values) == 0 {
+			return nil, fmt.Errorf("`%s` has no values", key)
+		}
+		next := make([]Vars, 0, len(combinations)*len(values))
+		for _, vars := range combinations {
+			for _, value := range values {
+				next = append(next, vars.with(key, value))
+			}
+		}
+		combinations = next
+	}
+	return combinations, nil
+}
+
+// matrixName names a combination of the matrix of an item, e.g. `clang[arch=x86_64,os=linux]`.
+func matrixName(name string, vars Vars) string {
+	if len(vars) == 0 {
+		return name
+	}
+	values := make([]string, 0, len(vars))
+	for _, key := range sortedKeys(vars) {
+		values = append(values, key+"="+vars[key])
+	}
+	return name + "[" + strings.Join(values, ",") + "]"
+}
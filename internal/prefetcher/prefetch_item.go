@@ -1,26 +1,136 @@
 package prefetcher
 
+import (
+	"internal/common"
+	"log"
+	"slices"
+	"strings"
+)
+
 type PrefetchMatchers struct {
-	Name        string
+	Name string
+	// Vars are preset values of the matchers' `{NAME}`, e.g. a combination of the config's matrix
+	Vars        Vars
+	Params      []PrefetchParam
 	UrlMatcher  PrefetchMatcher
 	HashMatcher PrefetchMatcher
 }
 
+// PrefetchParam is a named value of an item found by a matcher,
+// which the other matchers of the item use as `{NAME}`.
+type PrefetchParam struct {
+	Name    string
+	Matcher PrefetchMatcher
+}
+
 type PrefetchItem struct {
 	// initial information
+	Name string
 	Url  string
-	Hash string
+	// Urls are all URLs of the rule the item was found in, Url being the one to
+	// download. Bazel derives the canonical id of the download from them.
+	Urls []string
+	// CanonicalId is the `canonical_id` set by the rule, if any
+	CanonicalId string
+	Hash        string
+	// HashType is the algorithm of Hash, e.g. `sha256`
+	HashType string
+	// Source is where the item was found, e.g. `third_party/foo/deps.bzl:12`
+	Source string
 
 	// updated after download
-	Path      string
-	HashOfUrl string
-	Size      int64
+	Path string
+	// ServedBy is the URL of Mirrors the file was downloaded from
+	ServedBy string
+	// HashOfCanonicalId is the hash in the name of the `id-<hash>` file of the item, see IdFile
+	HashOfCanonicalId string
+	Size              int64
 
 	Error error
 }
 
+// Checksum returns the hash of the item, which tells where it goes in bazel's repository cache.
+func (i *PrefetchItem) Checksum() *common.Checksum {
+	hashType := i.HashType
+	if hashType == "" {
+		hashType = common.DefaultHashAlgorithm
+	}
+	return &common.Checksum{Algorithm: hashType, Hex: i.Hash}
+}
+
+// BazelCanonicalId returns the canonical id bazel looks the item up by in its repository
+// cache: the canonical_id of the rule, or else all of its URLs joined by spaces.
+func (i *PrefetchItem) BazelCanonicalId() string {
+	if i.CanonicalId != "" {
+		return i.CanonicalId
+	}
+	if len(i.Urls) > 0 {
+		return strings.Join(i.Urls, " ")
+	}
+	return i.Url
+}
+
+// Mirrors returns the URLs the item can be downloaded from, in the order to try
+// them: Url, then the other URLs of the rule.
+func (i *PrefetchItem) Mirrors() []string {
+	mirrors := []string{i.Url}
+	for _, url := range i.Urls {
+		if !slices.Contains(mirrors, url) {
+			mirrors = append(mirrors, url)
+		}
+	}
+	return mirrors
+}
+
+// IdFile returns the name of the file which tells bazel that the entry of the item in
+// its repository cache was downloaded for the canonical id of the item.
+func (i *PrefetchItem) IdFile() (string, error) {
+	hash, err := i.Checksum().IdHash(i.BazelCanonicalId())
+	if err != nil {
+		return "", err
+	}
+	return "id-" + hash, nil
+}
+
 type PrefetchMatcher interface {
-	// Match checks if the given URL matches the prefetch item.
-	// It returns true if it matches, false otherwise.
-	Match() (bool, string, error)
+	// Match returns every value the matcher finds, in the order they appear in the source.
+	// It returns an empty slice if nothing matches. vars are the params of the item.
+	// Matchers log to logger, the standard logger if nil, as items are analyzed in parallel.
+	Match(logger *log.Logger, vars Vars) ([]*MatchResult, error)
+}
+
+// MatchResult is one value found by a PrefetchMatcher.
+type MatchResult struct {
+	Value string
+
+	// where the value was found; empty for matchers which don't read files
+	File string
+	Line int
+
+	// Scopes identifies the brackets enclosing the value, innermost first.
+	// URLs and hashes found by different matchers are paired by them.
+	Scopes []string
+}
+
+// PrefetchItemMatcher is implemented by matchers which find complete items
+// (URL and hash together) instead of a single string, e.g. by parsing http_archive rules.
+// When the URL matcher of a PrefetchMatchers implements it, the hash matcher is not used.
+type PrefetchItemMatcher interface {
+	MatchItems(logger *log.Logger, vars Vars) ([]*PrefetchItem, error)
+}
+
+// PrefetchHashMatcher is implemented by hash matchers which find the hash of a
+// given URL, e.g. in a checksum file published next to it. When the hash matcher
+// of a PrefetchMatchers implements it, it is asked once for every URL.
+type PrefetchHashMatcher interface {
+	MatchHash(logger *log.Logger, url string, vars Vars) (*MatchResult, error)
+}
+
+// urlsOf returns the URLs of the items found by a PrefetchItemMatcher as match results.
+func urlsOf(items []*PrefetchItem) []*MatchResult {
+	results := make([]*MatchResult, 0, len(items))
+	for _, item := range items {
+		results = append(results, &MatchResult{Value: item.Url})
+	}
+	return results
 }
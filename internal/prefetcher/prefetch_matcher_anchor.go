@@ -1,7 +1,6 @@
 package prefetcher
 
 import (
-	"fmt"
 	"internal/common"
 	"log"
 	"os"
@@ -17,41 +16,58 @@ type PrefetchMatcherAnchor struct {
 	format   string
 }
 
-func (m *PrefetchMatcherAnchor) Match() (bool, string, error) {
-	l := common.NewLoggerWithPrefixAndColor("PrefetchMatcherAnchor: ")
-	l.Printf("Analyzing file: %s", m.file)
+// Match returns the first match of the regex after each line matching the anchor.
+func (m *PrefetchMatcherAnchor) Match(logger *log.Logger, vars Vars) ([]*MatchResult, error) {
+	file := vars.expand(m.file)
+	regexStr := vars.expandRegex(m.regexStr)
+	l := common.NewLoggerWithPrefixAndColorTo(logger, "PrefetchMatcherAnchor: ")
+	l.Printf("Analyzing file: %s", file)
 
-	regex, err := regexp.Compile(m.regexStr)
+	regex, err := regexp.Compile(regexStr)
 	if err != nil {
-		return false, "", err
+		return nil, err
 	}
 
-	anchorRegex, err := regexp.Compile(m.anchor)
+	anchorRegex, err := regexp.Compile(vars.expandRegex(m.anchor))
 	if err != nil {
-		return false, "", err
+		return nil, err
 	}
 
-	content, err := os.ReadFile(m.file)
+	content, err := os.ReadFile(file)
 	if err != nil {
-		log.Printf("Failed to read file %s: %v", m.file, err)
-		return false, "", err
+		l.Printf("Failed to read file %s: %v", file, err)
+		return nil, err
 	}
 
+	scopes := newFileScopes(file, string(content))
+	results := make([]*MatchResult, 0)
 	lines := strings.Split(string(content), "\n")
+	offsets := make([]int, len(lines))
+	for i := 1; i < len(lines); i++ {
+		offsets[i] = offsets[i-1] + len(lines[i-1]) + 1
+	}
 	for i, line := range lines {
 		matchedAnchor := anchorRegex.MatchString(line)
 		if matchedAnchor {
-			for j := i; j < i+m.maxLine; j++ {
+			for j := i; j < i+m.maxLine && j < len(lines); j++ {
 				line2 := lines[j]
-				if matches := regex.FindStringSubmatch(line2); len(matches) >= 2 {
-					result := fmt.Sprintf(m.format, matches[1])
+				if matches := regex.FindStringSubmatchIndex(line2); matches != nil {
+					result := formatMatch(m.format, submatches(line2, matches), regex.SubexpNames(), vars)
 					l.Printf("Found match at line %d: %s", j, result)
-					return true, result, nil
+					results = append(results, &MatchResult{
+						Value:  result,
+						File:   file,
+						Line:   j + 1,
+						Scopes: scopes.at(offsets[j] + valueOffset(matches)),
+					})
+					break
 				}
 			}
 		}
 	}
 
-	l.Printf("No match found for item %s in file %s", m.regexStr, m.file)
-	return false, "", nil
+	if len(results) == 0 {
+		l.Printf("No match found for item %s in file %s", regexStr, file)
+	}
+	return results, nil
 }
@@ -0,0 +1,121 @@
+package prefetcher
+
+import (
+	"internal/common"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// PrefetchMatcherBlock finds blocks of balanced (), [] or {} by an anchor regex,
+// and returns the matches of the regex inside each block. Both regexes run on
+// the whole content, not line by line, so they can span lines, e.g. with `(?s)`.
+//
+// The block of an anchor is the first bracket opened in the anchor match, e.g.
+// `http_archive\(`, otherwise the innermost block around it, e.g. for
+// `name = "foo"`, otherwise the first block opened after it.
+//
+// The URL and the hash matcher of an item using the same anchor find values in
+// the same blocks, so each URL gets the hash of its own block.
+type PrefetchMatcherBlock struct {
+	file   string
+	anchor string
+	regex  string
+	format string
+}
+
+func (m *PrefetchMatcherBlock) Match(logger *log.Logger, vars Vars) ([]*MatchResult, error) {
+	file := vars.expand(m.file)
+	l := common.NewLoggerWithPrefixAndColorTo(logger, "PrefetchMatcherBlock: ")
+	l.Printf("Analyzing file: %s", file)
+
+	anchor, err := regexp.Compile(vars.expandRegex(m.anchor))
+	if err != nil {
+		return nil, err
+	}
+	pattern, err := regexp.Compile(vars.expandRegex(m.regex))
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		l.Printf("Failed to read file %s: %v", file, err)
+		return nil, err
+	}
+	content := string(data)
+
+	spans := findBracketSpans(content)
+	scopes := newFileScopes(file, content)
+	results := make([]*MatchResult, 0)
+	seen := make(map[bracketSpan]bool)
+	for _, match := range anchor.FindAllStringIndex(content, -1) {
+		block, ok := anchorBlock(spans, match[0], match[1])
+		if !ok {
+			l.Printf("No block found for anchor at line %d of %s", lineAt(content, match[0]), file)
+			continue
+		}
+		if seen[block] {
+			continue
+		}
+		seen[block] = true
+
+		inside := content[:block.end]
+		for _, matches := range pattern.FindAllStringSubmatchIndex(inside[block.start+1:], -1) {
+			for i := range matches {
+				if matches[i] >= 0 {
+					matches[i] += block.start + 1
+				}
+			}
+			offset := valueOffset(matches)
+			results = append(results, &MatchResult{
+				Value:  formatMatch(m.format, submatches(content, matches), pattern.SubexpNames(), vars),
+				File:   file,
+				Line:   lineAt(content, offset),
+				Scopes: scopes.at(offset),
+			})
+		}
+	}
+
+	if len(results) == 0 {
+		l.Printf("No match found for %s in blocks of %s in file %s", m.regex, m.anchor, file)
+	} else {
+		l.Printf("Found %d matches in %d blocks of file %s", len(results), len(seen), file)
+	}
+	return results, nil
+}
+
+// anchorBlock returns the block of an anchor matched from start to end.
+func anchorBlock(spans []bracketSpan, start int, end int) (bracketSpan, bool) {
+	var opened, enclosing, after *bracketSpan
+	for i := range spans {
+		span := &spans[i]
+		switch {
+		case span.start >= start && span.start < end:
+			if opened == nil || span.start < opened.start {
+				opened = span
+			}
+		case span.start < start && span.end >= end:
+			if enclosing == nil || span.start > enclosing.start {
+				enclosing = span
+			}
+		case span.start >= end:
+			if after == nil || span.start < after.start {
+				after = span
+			}
+		}
+	}
+
+	for _, span := range []*bracketSpan{opened, enclosing, after} {
+		if span != nil {
+			return *span, true
+		}
+	}
+	return bracketSpan{}, false
+}
+
+// lineAt returns the 1-based line of offset in content.
+func lineAt(content string, offset int) int {
+	return strings.Count(content[:offset], "\n") + 1
+}
@@ -0,0 +1,297 @@
+package prefetcher
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"internal/common"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const BzlmodLockfileName = "MODULE.bazel.lock"
+
+var sha256HexRegex = regexp.MustCompile(`^[0-9a-fA-F]{64}$`)
+
+// PrefetchMatcherBzlmodLockfile reads MODULE.bazel.lock and returns an item for every
+// remote file recorded in it:
+//   - registry files (bazel_registry.json, MODULE.bazel, source.json) from `registryFileHashes`
+//   - the archives, patches and overlay files described by each registry source.json
+//   - repos with URLs generated by module extensions, and repo specs of older lockfile versions
+type PrefetchMatcherBzlmodLockfile struct {
+	file       string
+	downloader Downloader
+	sources    *sourceJsonCache // may be nil
+}
+
+// sourceJsonCache keeps the items of the source.json files the lockfiles point to.
+// The lockfile pins them by hash, so each is only downloaded once, not on every analysis.
+type sourceJsonCache struct {
+	mutex sync.Mutex
+	items map[string][]*PrefetchItem // by URL and hash
+}
+
+// get returns copies of the items of a source.json, as analysis changes them.
+func (c *sourceJsonCache) get(url string, hash string) ([]*PrefetchItem, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	cached, ok := c.items[url+" "+hash]
+	items := make([]*PrefetchItem, 0, len(cached))
+	for _, item := range cached {
+		copied := *item
+		items = append(items, &copied)
+	}
+	return items, ok
+}
+
+func (c *sourceJsonCache) put(url string, hash string, items []*PrefetchItem) {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.items == nil {
+		c.items = make(map[string][]*PrefetchItem)
+	}
+	cached := make([]*PrefetchItem, 0, len(items))
+	for _, item := range items {
+		copied := *item
+		cached = append(cached, &copied)
+	}
+	c.items[url+" "+hash] = cached
+}
+
+// sourceJson is the content of a registry's modules/<name>/<version>/source.json
+type sourceJson struct {
+	Type       string            `json:"type"`
+	Url        string            `json:"url"`
+	MirrorUrls []string          `json:"mirror_urls"`
+	Integrity  string            `json:"integrity"`
+	Patches    map[string]string `json:"patches"`
+	Overlay    map[string]string `json:"overlay"`
+}
+
+func (m *PrefetchMatcherBzlmodLockfile) Match(logger *log.Logger, vars Vars) ([]*MatchResult, error) {
+	items, err := m.MatchItems(logger, vars)
+	if err != nil {
+		return nil, err
+	}
+	return urlsOf(items), nil
+}
+
+func (m *PrefetchMatcherBzlmodLockfile) MatchItems(logger *log.Logger, vars Vars) ([]*PrefetchItem, error) {
+	file := vars.expand(m.file)
+	l := common.NewLoggerWithPrefixAndColorTo(logger, "PrefetchMatcherBzlmodLockfile: ")
+	l.Printf("Analyzing file: %s", file)
+
+	if !common.FileExists(file) {
+		l.Printf("Lockfile %s does not exist, skipping.", file)
+		return nil, nil
+	}
+
+	content, err := os.ReadFile(file)
+	if err != nil {
+		l.Printf("Failed to read file %s: %v", file, err)
+		return nil, err
+	}
+
+	var lockfile map[string]interface{}
+	if err := json.Unmarshal(content, &lockfile); err != nil {
+		l.Printf("Failed to parse file %s: %v", file, err)
+		return nil, err
+	}
+	l.Printf("Lockfile version: %v", lockfile["lockFileVersion"])
+
+	items := make([]*PrefetchItem, 0)
+	seen := make(map[string]bool)
+	add := func(item *PrefetchItem) {
+		if item.Url == "" || seen[item.Url] {
+			return
+		}
+		seen[item.Url] = true
+		items = append(items, item)
+	}
+
+	// registry files, and what their source.json point to
+	registryFileHashes, _ := lockfile["registryFileHashes"].(map[string]interface{})
+	urls := make([]string, 0, len(registryFileHashes))
+	for url := range registryFileHashes {
+		urls = append(urls, url)
+	}
+	sort.Strings(urls)
+	for _, url := range urls {
+		hash, ok := registryFileHashes[url].(string)
+		if !ok || !sha256HexRegex.MatchString(hash) {
+			continue
+		}
+		add(&PrefetchItem{Url: url, Hash: strings.ToLower(hash)})
+
+		if !strings.HasSuffix(url, "/source.json") {
+			continue
+		}
+		sourceItems, err := m.sourceJsonItems(url, hash)
+		if err != nil {
+			l.Printf("Failed to get archive of %s: %v", url, err)
+			return nil, fmt.Errorf("failed to get archive of %s: %w", url, err)
+		}
+		for _, item := range sourceItems {
+			add(item)
+		}
+	}
+
+	// everything else with URLs: extension-generated repos, and repoSpecs of older lockfiles
+	for _, item := range repoSpecItems(lockfile, "") {
+		add(item)
+	}
+
+	l.Printf("Found %d items in %s", len(items), file)
+	return items, nil
+}
+
+// sourceJsonItems fetches a registry source.json with the downloader, verifies it
+// against the hash recorded in the lockfile, and returns the files it describes.
+func (m *PrefetchMatcherBzlmodLockfile) sourceJsonItems(url string, expectedHash string) ([]*PrefetchItem, error) {
+	if items, ok := m.sources.get(url, expectedHash); ok {
+		return items, nil
+	}
+
+	content, err := downloadContent(m.downloader, url)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := fmt.Sprintf("%x", sha256.Sum256(content))
+	if hash != strings.ToLower(expectedHash) {
+		return nil, fmt.Errorf("hash of %s does not match. Expected: %s, Actual: %s", url, expectedHash, hash)
+	}
+
+	items, err := sourceJsonContentItems(url, content)
+	if err != nil {
+		return nil, err
+	}
+	m.sources.put(url, expectedHash, items)
+	return items, nil
+}
+
+// sourceJsonContentItems returns the files described by the source.json at url.
+func sourceJsonContentItems(url string, content []byte) ([]*PrefetchItem, error) {
+	var source sourceJson
+	if err := json.Unmarshal(content, &source); err != nil {
+		return nil, err
+	}
+	if source.Type != "" && source.Type != "archive" {
+		// git_repository and local_path have nothing to download
+		return nil, nil
+	}
+
+	moduleDir := strings.TrimSuffix(url, "source.json")
+	// name the items like bazel names the module repos: <module>+<version>
+	parts := strings.Split(strings.TrimSuffix(moduleDir, "/"), "/")
+	name := strings.Join(parts[len(parts)-2:], "+")
+
+	// bazel downloads the archive from the mirrors too, so they are in its canonical id
+	items := []*PrefetchItem{{
+		Name: name,
+		Url:  source.Url,
+		Urls: append([]string{source.Url}, source.MirrorUrls...),
+		Hash: source.Integrity,
+	}}
+	for _, patch := range sortedKeys(source.Patches) {
+		items = append(items, &PrefetchItem{
+			Name: name,
+			Url:  moduleDir + "patches/" + patch,
+			Hash: source.Patches[patch],
+		})
+	}
+	for _, file := range sortedKeys(source.Overlay) {
+		items = append(items, &PrefetchItem{
+			Name: name,
+			Url:  moduleDir + "overlay/" + file,
+			Hash: source.Overlay[file],
+		})
+	}
+	return items, nil
+}
+
+// repoSpecItems walks the lockfile looking for repo specs, i.e. objects with an
+// `attributes` map, and returns an item for each of them that has URLs.
+func repoSpecItems(node interface{}, key string) []*PrefetchItem {
+	var items []*PrefetchItem
+	switch n := node.(type) {
+	case map[string]interface{}:
+		if attributes, ok := n["attributes"].(map[string]interface{}); ok {
+			items = append(items, attributesToItems(attributes, key)...)
+		}
+		for _, k := range sortedKeys(n) {
+			items = append(items, repoSpecItems(n[k], k)...)
+		}
+	case []interface{}:
+		for _, i := range n {
+			items = append(items, repoSpecItems(i, key)...)
+		}
+	}
+	return items
+}
+
+// attributesToItems converts the attributes of an http_archive-like repo spec to items.
+func attributesToItems(attributes map[string]interface{}, name string) []*PrefetchItem {
+	if n, ok := attributes["name"].(string); ok && n != "" {
+		name = n
+	}
+
+	var urls []string
+	if url, ok := attributes["url"].(string); ok && url != "" {
+		urls = append(urls, url)
+	}
+	if list, ok := starlarkStringList(attributes["urls"]); ok {
+		urls = append(urls, list...)
+	}
+
+	var items []*PrefetchItem
+	if len(urls) > 0 {
+		hash, _ := attributes["sha256"].(string)
+		if hash == "" {
+			hash, _ = attributes["integrity"].(string)
+		}
+		canonicalId, _ := attributes["canonical_id"].(string)
+		items = append(items, &PrefetchItem{
+			Name:        name,
+			Url:         urls[0],
+			Urls:        urls,
+			CanonicalId: canonicalId,
+			Hash:        hash,
+		})
+	}
+
+	// registry modules of older lockfile versions: {"remote_patches": {"<url>": "<integrity>"}}
+	if patches, ok := attributes["remote_patches"].(map[string]interface{}); ok {
+		for _, url := range sortedKeys(patches) {
+			integrity, _ := patches[url].(string)
+			items = append(items, &PrefetchItem{
+				Name: name,
+				Url:  url,
+				Hash: integrity,
+			})
+		}
+	}
+
+	return items
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
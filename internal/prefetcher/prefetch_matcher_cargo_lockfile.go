@@ -0,0 +1,111 @@
+package prefetcher
+
+import (
+	"fmt"
+	"internal/common"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+const (
+	CargoLockfileName     = "Cargo.lock"
+	DefaultCratesRegistry = "https://static.crates.io/crates"
+)
+
+// sources of crates.io packages: the git index, and the sparse one since cargo 1.68
+var cratesIoSources = map[string]bool{
+	"registry+https://github.com/rust-lang/crates.io-index": true,
+	"sparse+https://index.crates.io/":                       true,
+}
+
+// `"checksum <name> <version> (<source>)" = "<sha256>"` in [metadata] of version 1 lockfiles
+var cargoMetadataChecksumRegex = regexp.MustCompile(`^checksum (\S+) (\S+) \((.+)\)$`)
+
+// PrefetchMatcherCargoLockfile reads a Cargo.lock, as used by rules_rust
+// crate_universe, and returns an item for every crates.io package in it.
+//
+// Crates are downloaded from registry, static.crates.io by default, with the URL
+// crate_universe gives to bazel: `<registry>/<name>/<version>/download`.
+type PrefetchMatcherCargoLockfile struct {
+	file     string
+	registry string
+}
+
+type cargoLockfile struct {
+	Packages []cargoPackage    `toml:"package"`
+	Metadata map[string]string `toml:"metadata"`
+}
+
+type cargoPackage struct {
+	Name     string `toml:"name"`
+	Version  string `toml:"version"`
+	Source   string `toml:"source"`
+	Checksum string `toml:"checksum"`
+}
+
+func (m *PrefetchMatcherCargoLockfile) Match(logger *log.Logger, vars Vars) ([]*MatchResult, error) {
+	items, err := m.MatchItems(logger, vars)
+	if err != nil {
+		return nil, err
+	}
+	return urlsOf(items), nil
+}
+
+func (m *PrefetchMatcherCargoLockfile) MatchItems(logger *log.Logger, vars Vars) ([]*PrefetchItem, error) {
+	file := vars.expand(m.file)
+	l := common.NewLoggerWithPrefixAndColorTo(logger, "PrefetchMatcherCargoLockfile: ")
+	l.Printf("Analyzing file: %s", file)
+
+	var lockfile cargoLockfile
+	if _, err := toml.DecodeFile(file, &lockfile); err != nil {
+		l.Printf("Failed to parse file %s: %v", file, err)
+		return nil, err
+	}
+
+	registry := strings.TrimSuffix(m.registry, "/")
+	if registry == "" {
+		registry = DefaultCratesRegistry
+	}
+
+	items := make([]*PrefetchItem, 0)
+	seen := make(map[string]bool)
+	checksums := metadataChecksums(lockfile.Metadata)
+	for _, pkg := range lockfile.Packages {
+		if !cratesIoSources[pkg.Source] {
+			// the workspace's own crates, path and git dependencies
+			continue
+		}
+		checksum := pkg.Checksum
+		if checksum == "" {
+			checksum = checksums[pkg.Name+" "+pkg.Version]
+		}
+
+		url := fmt.Sprintf("%s/%s/%s/download", registry, pkg.Name, pkg.Version)
+		if seen[url] {
+			continue
+		}
+		seen[url] = true
+		items = append(items, &PrefetchItem{
+			Name: pkg.Name + "-" + pkg.Version,
+			Url:  url,
+			Hash: checksum,
+		})
+	}
+
+	l.Printf("Found %d crates in %s", len(items), file)
+	return items, nil
+}
+
+// metadataChecksums returns the checksums of version 1 lockfiles by `<name> <version>`.
+func metadataChecksums(metadata map[string]string) map[string]string {
+	checksums := make(map[string]string)
+	for key, checksum := range metadata {
+		if match := cargoMetadataChecksumRegex.FindStringSubmatch(key); match != nil && cratesIoSources[match[3]] {
+			checksums[match[1]+" "+match[2]] = checksum
+		}
+	}
+	return checksums
+}
@@ -0,0 +1,162 @@
+package prefetcher
+
+import (
+	"fmt"
+	"internal/common"
+	"log"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// how long a downloaded checksum file is reused, e.g. a SHA256SUMS shared by several URLs
+const checksumFileCacheTime = 10 * time.Minute
+
+var (
+	hexHashRegex = regexp.MustCompile(`^[0-9a-fA-F]{40,128}$`)
+	// BSD style: `SHA256 (file.tar.gz) = <hex>`
+	bsdChecksumRegex = regexp.MustCompile(`^\w+ \((.+)\) = ([0-9a-fA-F]+)$`)
+)
+
+// PrefetchMatcherChecksumFile is a hash matcher which downloads a checksum file
+// published next to the artifact, and returns the hash of the artifact in it.
+//
+// It is asked once for every URL, which is available as `{URL}`, so the checksum
+// file can be e.g. `{URL}.sha256` or `https://example.com/v{VERSION}/SHA256SUMS`.
+// The entry of the artifact is the line naming the basename of its URL, as written
+// by sha256sum or `shasum -a 256 --tag`, or else the only line with a bare hash,
+// without a file name. If regex is set, its first group is the hash instead.
+type PrefetchMatcherChecksumFile struct {
+	url        string
+	regex      string
+	downloader Downloader
+
+	mutex sync.Mutex
+	cache map[string]*checksumFileDownload
+}
+
+// checksumFileDownload is a checksum file being downloaded, or downloaded, once for all
+// URLs asking for it at the same time.
+type checksumFileDownload struct {
+	done       chan struct{} // closed when the fields below are set
+	content    string
+	err        error
+	downloaded time.Time
+}
+
+// expired tells if the file needs to be downloaded again. A download which is still
+// running is not expired, a failed one is.
+func (d *checksumFileDownload) expired() bool {
+	select {
+	case <-d.done:
+		return d.err != nil || time.Since(d.downloaded) >= checksumFileCacheTime
+	default:
+		return false
+	}
+}
+
+func (m *PrefetchMatcherChecksumFile) Match(logger *log.Logger, vars Vars) ([]*MatchResult, error) {
+	url, ok := vars["URL"]
+	if !ok {
+		return nil, fmt.Errorf("checksum_file matcher can only be used as a hash matcher")
+	}
+	result, err := m.MatchHash(logger, url, vars)
+	if err != nil {
+		return nil, err
+	}
+	return []*MatchResult{result}, nil
+}
+
+func (m *PrefetchMatcherChecksumFile) MatchHash(logger *log.Logger, url string, vars Vars) (*MatchResult, error) {
+	vars = vars.with("URL", url)
+	checksumUrl := vars.expand(m.url)
+	l := common.NewLoggerWithPrefixAndColorTo(logger, "PrefetchMatcherChecksumFile: ")
+
+	content, err := m.download(checksumUrl)
+	if err != nil {
+		l.Printf("Failed to download checksum file %s: %v", checksumUrl, err)
+		return nil, err
+	}
+
+	hash, line, err := m.findHash(content, url, vars)
+	if err != nil {
+		l.Printf("No hash of %s in %s: %v", url, checksumUrl, err)
+		return nil, err
+	}
+	l.Printf("Hash of %s in %s: %s", url, checksumUrl, hash)
+	return &MatchResult{Value: hash, File: checksumUrl, Line: line}, nil
+}
+
+// findHash returns the hash of the artifact at url in a checksum file, and its line.
+func (m *PrefetchMatcherChecksumFile) findHash(content string, url string, vars Vars) (string, int, error) {
+	lines := strings.Split(content, "\n")
+
+	if m.regex != "" {
+		pattern, err := regexp.Compile(vars.expandRegex(m.regex))
+		if err != nil {
+			return "", 0, err
+		}
+		for i, line := range lines {
+			if match := pattern.FindStringSubmatch(line); match != nil {
+				return formatMatch("", match, nil, vars), i + 1, nil
+			}
+		}
+		return "", 0, fmt.Errorf("no line matches %s", pattern)
+	}
+
+	basename := path.Base(strings.SplitN(url, "?", 2)[0])
+	var hashes []int
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if match := bsdChecksumRegex.FindStringSubmatch(line); match != nil {
+			if path.Base(match[1]) == basename {
+				return match[2], i + 1, nil
+			}
+			continue
+		}
+
+		// sha256sum style: `<hex>  file.tar.gz`, `<hex> *file.tar.gz`, or only `<hex>`
+		fields := strings.Fields(line)
+		if len(fields) == 0 || !hexHashRegex.MatchString(fields[0]) {
+			continue
+		}
+		if len(fields) >= 2 {
+			if path.Base(strings.TrimPrefix(fields[1], "*")) == basename {
+				return fields[0], i + 1, nil
+			}
+			// the hash of another file
+			continue
+		}
+		hashes = append(hashes, i)
+	}
+
+	if len(hashes) == 1 {
+		return strings.Fields(lines[hashes[0]])[0], hashes[0] + 1, nil
+	}
+	return "", 0, fmt.Errorf("no entry for %s", basename)
+}
+
+// download returns the content of a checksum file. The mutex is only held to find
+// or start the download, so checksum files are downloaded in parallel.
+func (m *PrefetchMatcherChecksumFile) download(url string) (string, error) {
+	m.mutex.Lock()
+	d, ok := m.cache[url]
+	if ok && !d.expired() {
+		m.mutex.Unlock()
+		<-d.done
+		return d.content, d.err
+	}
+	d = &checksumFileDownload{done: make(chan struct{})}
+	if m.cache == nil {
+		m.cache = make(map[string]*checksumFileDownload)
+	}
+	m.cache[url] = d
+	m.mutex.Unlock()
+
+	content, err := downloadContent(m.downloader, url)
+	d.content, d.err, d.downloaded = string(content), err, time.Now()
+	close(d.done)
+	return d.content, d.err
+}
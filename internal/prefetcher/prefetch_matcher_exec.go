@@ -0,0 +1,105 @@
+package prefetcher
+
+import (
+	"encoding/json"
+	"fmt"
+	"internal/common"
+	"io"
+	"log"
+	"strings"
+	"time"
+)
+
+const DefaultExecTimeout = 5 * time.Minute
+
+// PrefetchMatcherExec runs a command in the source dir, which prints the items it
+// finds to stdout as a JSON array:
+//
+//	[{"url": "https://...", "hash": "<hex or sha256-<base64>>", "name": "..."}]
+//
+// Only `url` is required. Items downloaded by bazel from several URLs can list
+// all of them as `urls`, and set `canonical_id` like their rule, so bazel finds
+// them in its cache. The `{NAME}` params of the item are expanded in the
+// arguments. The command is killed after timeout.
+type PrefetchMatcherExec struct {
+	srcDir  string
+	cmd     []string
+	timeout time.Duration
+}
+
+// execItem is an item printed by the command of an exec matcher
+type execItem struct {
+	Url         string   `json:"url"`
+	Urls        []string `json:"urls"`
+	CanonicalId string   `json:"canonical_id"`
+	Hash        string   `json:"hash"`
+	Name        string   `json:"name"`
+}
+
+func (m *PrefetchMatcherExec) Match(logger *log.Logger, vars Vars) ([]*MatchResult, error) {
+	items, err := m.MatchItems(logger, vars)
+	if err != nil {
+		return nil, err
+	}
+	return urlsOf(items), nil
+}
+
+func (m *PrefetchMatcherExec) MatchItems(logger *log.Logger, vars Vars) ([]*PrefetchItem, error) {
+	l := common.NewLoggerWithPrefixAndColorTo(logger, "PrefetchMatcherExec: ")
+	if len(m.cmd) == 0 {
+		return nil, fmt.Errorf("exec matcher has no cmd")
+	}
+
+	args := make([]string, 0, len(m.cmd)-1)
+	for _, arg := range m.cmd[1:] {
+		args = append(args, vars.expand(arg))
+	}
+
+	l.Printf("Run command: %s, %v", m.cmd[0], args)
+	var stdout []byte
+	var readErr error
+	err := common.RunCmdWithLogger(logger, m.srcDir, m.timeout, m.cmd[0], args, func(r io.ReadCloser) {
+		stdout, readErr = io.ReadAll(r)
+	})
+	if err != nil {
+		l.Printf("Command %s failed: %v", m.cmd[0], err)
+		return nil, err
+	}
+	if readErr != nil {
+		return nil, fmt.Errorf("failed to read output of %s: %w", m.cmd[0], readErr)
+	}
+
+	var found []execItem
+	if err := json.Unmarshal(stdout, &found); err != nil {
+		err = fmt.Errorf("output of %s is not a JSON array of {url, hash, name}: %w, output: %s", m.cmd[0], err, truncate(strings.TrimSpace(string(stdout)), 200))
+		l.Print(err.Error())
+		return nil, err
+	}
+
+	items := make([]*PrefetchItem, 0, len(found))
+	for i, item := range found {
+		if strings.TrimSpace(item.Url) == "" {
+			err := fmt.Errorf("item #%d printed by %s has no url", i, m.cmd[0])
+			l.Print(err.Error())
+			return nil, err
+		}
+		items = append(items, &PrefetchItem{
+			Name:        item.Name,
+			Url:         item.Url,
+			Urls:        item.Urls,
+			CanonicalId: item.CanonicalId,
+			Hash:        item.Hash,
+			Source:      "exec:" + m.cmd[0],
+		})
+	}
+
+	l.Printf("Command %s printed %d items", m.cmd[0], len(items))
+	return items, nil
+}
+
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	return s[:max] + "..."
+}
@@ -0,0 +1,135 @@
+package prefetcher
+
+import (
+	"fmt"
+	"internal/common"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+// PrefetchMatcherFiles runs a matcher on every file matched by the `file` of its
+// config, which can be a list, and globs like `third_party/**/deps.bzl`.
+// Results are tagged with the file they come from, relative to srcDir.
+type PrefetchMatcherFiles struct {
+	srcDir   string
+	patterns []string
+	matcher  func(file string) PrefetchMatcher
+}
+
+// PrefetchItemMatcherFiles is PrefetchMatcherFiles for matchers which find complete items.
+type PrefetchItemMatcherFiles struct {
+	PrefetchMatcherFiles
+}
+
+// newFilesMatcher returns a matcher running the matcher made by create on every file matched by patterns.
+func newFilesMatcher(srcDir string, patterns []string, create func(file string) PrefetchMatcher) PrefetchMatcher {
+	m := PrefetchMatcherFiles{srcDir: srcDir, patterns: patterns, matcher: create}
+	if _, ok := create("").(PrefetchItemMatcher); ok {
+		return &PrefetchItemMatcherFiles{m}
+	}
+	return &m
+}
+
+func (m *PrefetchMatcherFiles) Match(logger *log.Logger, vars Vars) ([]*MatchResult, error) {
+	files, err := m.files(logger, vars)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*MatchResult, 0)
+	for _, file := range files {
+		found, err := m.matcher(file).Match(logger, vars)
+		if err != nil {
+			return nil, err
+		}
+		for _, result := range found {
+			if result.File != "" {
+				result.File = m.relative(result.File)
+			}
+		}
+		results = append(results, found...)
+	}
+	return results, nil
+}
+
+func (m *PrefetchItemMatcherFiles) MatchItems(logger *log.Logger, vars Vars) ([]*PrefetchItem, error) {
+	files, err := m.files(logger, vars)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]*PrefetchItem, 0)
+	for _, file := range files {
+		found, err := m.matcher(file).(PrefetchItemMatcher).MatchItems(logger, vars)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range found {
+			if item.Source == "" {
+				item.Source = file
+			}
+			item.Source = m.relative(item.Source)
+		}
+		items = append(items, found...)
+	}
+	return items, nil
+}
+
+// files returns the files matched by the patterns, in order. Patterns without
+// glob characters are returned as they are, so that matchers report missing files.
+func (m *PrefetchMatcherFiles) files(logger *log.Logger, vars Vars) ([]string, error) {
+	l := common.NewLoggerWithPrefixAndColorTo(logger, "PrefetchMatcherFiles: ")
+
+	var files []string
+	seen := make(map[string]bool)
+	for _, pattern := range m.patterns {
+		pattern = path.Join(m.srcDir, vars.expand(pattern))
+
+		matches := []string{pattern}
+		if hasGlobMeta(pattern) {
+			var err error
+			matches, err = doublestar.FilepathGlob(pattern, doublestar.WithFilesOnly())
+			if err != nil {
+				return nil, fmt.Errorf("invalid file pattern %s: %w", pattern, err)
+			}
+			sort.Strings(matches)
+			l.Printf("%d files match %s", len(matches), pattern)
+		}
+
+		for _, file := range matches {
+			if !seen[file] {
+				seen[file] = true
+				files = append(files, file)
+			}
+		}
+	}
+
+	if len(files) == 0 {
+		l.Printf("No file matches %v", m.patterns)
+		return nil, os.ErrNotExist
+	}
+	return files, nil
+}
+
+// relative returns file relative to srcDir, so it reads the same on every machine.
+func (m *PrefetchMatcherFiles) relative(file string) string {
+	if rel, err := filepath.Rel(m.srcDir, file); err == nil && filepath.IsLocal(rel) {
+		return rel
+	}
+	return file
+}
+
+func hasGlobMeta(pattern string) bool {
+	for _, c := range pattern {
+		switch c {
+		case '*', '?', '[', '{':
+			return true
+		}
+	}
+	return false
+}
@@ -1,9 +1,11 @@
 package prefetcher
 
+import "log"
+
 type PrefetchMatcherHardcoded struct {
 	hardcoded string
 }
 
-func (m *PrefetchMatcherHardcoded) Match() (bool, string, error) {
-	return true, m.hardcoded, nil
+func (m *PrefetchMatcherHardcoded) Match(_ *log.Logger, vars Vars) ([]*MatchResult, error) {
+	return []*MatchResult{{Value: vars.expand(m.hardcoded)}}, nil
 }
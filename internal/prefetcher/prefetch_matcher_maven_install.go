@@ -0,0 +1,217 @@
+package prefetcher
+
+import (
+	"bytes"
+	"encoding/json"
+	"internal/common"
+	"log"
+	"os"
+	"strings"
+)
+
+const MavenInstallFileName = "maven_install.json"
+
+// PrefetchMatcherMavenInstall reads the maven_install.json pinned by rules_jvm_external
+// and returns an item for every artifact, with the URLs of every repository it can be
+// downloaded from, in the order rules_jvm_external passes them to http_file.
+//
+// Both formats are supported: the `dependency_tree` of version 1, which lists the
+// URL and the mirror URLs of each artifact, and the `artifacts` and `repositories`
+// of version 2, from which the URLs are built.
+type PrefetchMatcherMavenInstall struct {
+	file string
+}
+
+type mavenInstallFile struct {
+	DependencyTree *struct {
+		Dependencies []struct {
+			Coord      string   `json:"coord"`
+			Url        string   `json:"url"`
+			MirrorUrls []string `json:"mirror_urls"`
+			Sha256     string   `json:"sha256"`
+		} `json:"dependencies"`
+	} `json:"dependency_tree"`
+	Artifacts map[string]struct {
+		Version string             `json:"version"`
+		Shasums map[string]*string `json:"shasums"`
+	} `json:"artifacts"`
+	Repositories map[string][]string `json:"repositories"`
+	// the keys of Repositories in the order of the file, which is the order of the URLs
+	repositoryOrder []string
+}
+
+func (f *mavenInstallFile) UnmarshalJSON(data []byte) error {
+	// the fields, without this method
+	type fields mavenInstallFile
+	var raw struct {
+		fields
+		Repositories json.RawMessage `json:"repositories"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*f = mavenInstallFile(raw.fields)
+	if len(raw.Repositories) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(raw.Repositories, &f.Repositories); err != nil {
+		return err
+	}
+	order, err := jsonObjectKeys(raw.Repositories)
+	if err != nil {
+		return err
+	}
+	f.repositoryOrder = order
+	return nil
+}
+
+// jsonObjectKeys returns the keys of a JSON object in the order they are written.
+func jsonObjectKeys(data []byte) ([]string, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if token, err := decoder.Token(); err != nil {
+		return nil, err
+	} else if token != json.Delim('{') {
+		return nil, nil
+	}
+
+	var keys []string
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, token.(string))
+		// skip the value
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+func (m *PrefetchMatcherMavenInstall) Match(logger *log.Logger, vars Vars) ([]*MatchResult, error) {
+	items, err := m.MatchItems(logger, vars)
+	if err != nil {
+		return nil, err
+	}
+	return urlsOf(items), nil
+}
+
+func (m *PrefetchMatcherMavenInstall) MatchItems(logger *log.Logger, vars Vars) ([]*PrefetchItem, error) {
+	file := vars.expand(m.file)
+	l := common.NewLoggerWithPrefixAndColorTo(logger, "PrefetchMatcherMavenInstall: ")
+	l.Printf("Analyzing file: %s", file)
+
+	content, err := os.ReadFile(file)
+	if err != nil {
+		l.Printf("Failed to read file %s: %v", file, err)
+		return nil, err
+	}
+
+	var install mavenInstallFile
+	if err := json.Unmarshal(content, &install); err != nil {
+		l.Printf("Failed to parse file %s: %v", file, err)
+		return nil, err
+	}
+
+	items := make([]*PrefetchItem, 0)
+	seen := make(map[string]bool)
+	// url is downloaded, urls are all of them
+	add := func(name string, url string, urls []string, hash string) {
+		if url == "" || seen[url] {
+			return
+		}
+		seen[url] = true
+		items = append(items, &PrefetchItem{Name: name, Url: url, Urls: urls, Hash: hash})
+	}
+
+	if install.DependencyTree != nil {
+		for _, dependency := range install.DependencyTree.Dependencies {
+			// the mirror URLs of an artifact include its URL
+			urls := dependency.MirrorUrls
+			if len(urls) == 0 {
+				urls = []string{dependency.Url}
+			}
+			url := dependency.Url
+			if url == "" {
+				url = urls[0]
+			}
+			add(dependency.Coord, url, urls, dependency.Sha256)
+		}
+	}
+
+	// repositories list the artifacts they serve; artifacts not listed by any are tried in all of them
+	repositoriesOf := make(map[string][]string)
+	for _, repository := range install.repositoryOrder {
+		for _, key := range install.Repositories[repository] {
+			repositoriesOf[key] = append(repositoriesOf[key], repository)
+		}
+	}
+
+	for _, key := range sortedKeys(install.Artifacts) {
+		artifact := install.Artifacts[key]
+		for _, classifier := range sortedKeys(artifact.Shasums) {
+			// classified artifacts are listed as `group:artifact:packaging:classifier`
+			repositories := repositoriesOf[key]
+			if classifier != "jar" {
+				packagingKey := key
+				if strings.Count(key, ":") == 1 {
+					packagingKey += ":jar"
+				}
+				repositories = repositoriesOf[packagingKey+":"+classifier]
+			}
+			if len(repositories) == 0 {
+				repositories = install.repositoryOrder
+			}
+
+			hash := ""
+			if sha := artifact.Shasums[classifier]; sha != nil {
+				hash = *sha
+			}
+			var urls []string
+			var coordinates string
+			for _, repository := range repositories {
+				url, c, ok := mavenArtifactUrl(repository, key, artifact.Version, classifier)
+				if !ok {
+					l.Printf("Invalid artifact `%s`, skipping.", key)
+					urls = nil
+					break
+				}
+				urls = append(urls, url)
+				coordinates = c
+			}
+			if len(urls) > 0 {
+				add(coordinates, urls[0], urls, hash)
+			}
+		}
+	}
+
+	l.Printf("Found %d artifacts in %s", len(items), file)
+	return items, nil
+}
+
+// mavenArtifactUrl returns the URL and the coordinates of an artifact of a
+// version 2 maven_install.json, whose key is `group:artifact[:packaging]`.
+// The classifier `jar` is the artifact without classifier.
+func mavenArtifactUrl(repository string, key string, version string, classifier string) (string, string, bool) {
+	parts := strings.Split(key, ":")
+	if len(parts) < 2 || len(parts) > 3 || version == "" {
+		return "", "", false
+	}
+	group, artifact, packaging := parts[0], parts[1], "jar"
+	if len(parts) == 3 {
+		packaging = parts[2]
+	}
+
+	filename := artifact + "-" + version
+	coordinates := group + ":" + artifact + ":" + version
+	if classifier != "jar" {
+		filename += "-" + classifier
+		coordinates = group + ":" + artifact + ":" + packaging + ":" + classifier + ":" + version
+	}
+	filename += "." + packaging
+
+	url := strings.TrimSuffix(repository, "/") + "/" + strings.ReplaceAll(group, ".", "/") + "/" + artifact + "/" + version + "/" + filename
+	return url, coordinates, true
+}
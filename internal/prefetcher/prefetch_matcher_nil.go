@@ -1,8 +1,10 @@
 package prefetcher
 
+import "log"
+
 type PrefetchMatcherNil struct {
 }
 
-func (p *PrefetchMatcherNil) Match() (bool, string, error) {
-	return true, "", nil
+func (p *PrefetchMatcherNil) Match(_ *log.Logger, _ Vars) ([]*MatchResult, error) {
+	return []*MatchResult{{Value: ""}}, nil
 }
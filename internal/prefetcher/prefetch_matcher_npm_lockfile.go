@@ -0,0 +1,241 @@
+package prefetcher
+
+import (
+	"encoding/json"
+	"fmt"
+	"internal/common"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	PnpmLockfileName   = "pnpm-lock.yaml"
+	DefaultNpmRegistry = "https://registry.npmjs.org"
+)
+
+// PrefetchMatcherNpmLockfile reads a pnpm-lock.yaml (as used by rules_js) or a
+// package-lock.json and returns an item for every tarball in it, with its integrity.
+//
+// Tarballs whose URL the lockfile does not record (pnpm only records it for
+// non-registry packages) are downloaded from registry.
+type PrefetchMatcherNpmLockfile struct {
+	file     string
+	registry string
+}
+
+type pnpmLockfile struct {
+	LockfileVersion interface{}            `yaml:"lockfileVersion"`
+	Packages        map[string]pnpmPackage `yaml:"packages"`
+}
+
+type pnpmPackage struct {
+	Name       string `yaml:"name"`
+	Version    string `yaml:"version"`
+	Resolution struct {
+		Integrity string `yaml:"integrity"`
+		Tarball   string `yaml:"tarball"`
+	} `yaml:"resolution"`
+}
+
+type npmLockfile struct {
+	LockfileVersion int                      `json:"lockfileVersion"`
+	Packages        map[string]npmPackage    `json:"packages"`
+	Dependencies    map[string]npmDependency `json:"dependencies"`
+}
+
+// npmPackage is an entry of `packages`, in lockfile version 2 and 3
+type npmPackage struct {
+	Name      string `json:"name"` // only set if the key does not tell it, e.g. for workspaces
+	Version   string `json:"version"`
+	Resolved  string `json:"resolved"`
+	Integrity string `json:"integrity"`
+}
+
+// npmDependency is an entry of the nested `dependencies`, in lockfile version 1
+type npmDependency struct {
+	Version      string                   `json:"version"`
+	Resolved     string                   `json:"resolved"`
+	Integrity    string                   `json:"integrity"`
+	Dependencies map[string]npmDependency `json:"dependencies"`
+}
+
+func (m *PrefetchMatcherNpmLockfile) Match(logger *log.Logger, vars Vars) ([]*MatchResult, error) {
+	items, err := m.MatchItems(logger, vars)
+	if err != nil {
+		return nil, err
+	}
+	return urlsOf(items), nil
+}
+
+func (m *PrefetchMatcherNpmLockfile) MatchItems(logger *log.Logger, vars Vars) ([]*PrefetchItem, error) {
+	file := vars.expand(m.file)
+	l := common.NewLoggerWithPrefixAndColorTo(logger, "PrefetchMatcherNpmLockfile: ")
+	l.Printf("Analyzing file: %s", file)
+
+	content, err := os.ReadFile(file)
+	if err != nil {
+		l.Printf("Failed to read file %s: %v", file, err)
+		return nil, err
+	}
+
+	var found []*PrefetchItem
+	if ext := path.Ext(file); ext == ".yaml" || ext == ".yml" {
+		found, err = m.pnpmItems(content)
+	} else {
+		found, err = npmItems(content)
+	}
+	if err != nil {
+		l.Printf("Failed to parse file %s: %v", file, err)
+		return nil, err
+	}
+
+	items := make([]*PrefetchItem, 0, len(found))
+	seen := make(map[string]bool)
+	for _, item := range found {
+		if seen[item.Url] {
+			continue
+		}
+		seen[item.Url] = true
+		items = append(items, item)
+	}
+
+	l.Printf("Found %d tarballs in %s", len(items), file)
+	return items, nil
+}
+
+func (m *PrefetchMatcherNpmLockfile) pnpmItems(content []byte) ([]*PrefetchItem, error) {
+	var lockfile pnpmLockfile
+	if err := yaml.Unmarshal(content, &lockfile); err != nil {
+		return nil, err
+	}
+
+	// 5.4 up to pnpm 7, '6.0' and '9.0' later
+	lockfileVersion, _ := strconv.ParseFloat(fmt.Sprint(lockfile.LockfileVersion), 64)
+
+	registry := strings.TrimSuffix(m.registry, "/")
+	if registry == "" {
+		registry = DefaultNpmRegistry
+	}
+
+	var items []*PrefetchItem
+	for _, key := range sortedKeys(lockfile.Packages) {
+		pkg := lockfile.Packages[key]
+		name, version := parsePnpmPackageKey(key, lockfileVersion)
+		if pkg.Name != "" {
+			name = pkg.Name
+		}
+		if pkg.Version != "" {
+			version = pkg.Version
+		}
+
+		url := pkg.Resolution.Tarball
+		if url == "" {
+			if pkg.Resolution.Integrity == "" || name == "" || version == "" {
+				// git and directory dependencies have nothing to download
+				continue
+			}
+			url = npmTarballUrl(registry, name, version)
+		}
+		if !isHttpUrl(url) {
+			continue
+		}
+
+		items = append(items, &PrefetchItem{
+			Name: name + "@" + version,
+			Url:  url,
+			Hash: pkg.Resolution.Integrity,
+		})
+	}
+	return items, nil
+}
+
+// parsePnpmPackageKey returns the name and version of a package from its key in
+// `packages`, which is `/name/version` up to lockfile version 5,
+// `/name@version` in version 6 and `name@version` since version 9. Versions can
+// have a suffix telling the peer dependencies, like `_react@18.2.0` or `(react@18.2.0)`.
+func parsePnpmPackageKey(key string, lockfileVersion float64) (string, string) {
+	key = strings.TrimPrefix(key, "/")
+	if i := strings.Index(key, "("); i >= 0 {
+		key = key[:i]
+	}
+
+	separator := "@"
+	if lockfileVersion < 6 {
+		separator = "/"
+	}
+	i := strings.LastIndex(key, separator)
+	if i <= 0 {
+		return "", ""
+	}
+	name, version := key[:i], key[i+1:]
+	if j := strings.Index(version, "_"); j >= 0 && lockfileVersion < 6 {
+		version = version[:j]
+	}
+	return name, version
+}
+
+// npmTarballUrl returns the URL of a package tarball in an npm registry,
+// e.g. https://registry.npmjs.org/@babel/core/-/core-7.24.0.tgz
+func npmTarballUrl(registry string, name string, version string) string {
+	return registry + "/" + name + "/-/" + path.Base(name) + "-" + version + ".tgz"
+}
+
+func npmItems(content []byte) ([]*PrefetchItem, error) {
+	var lockfile npmLockfile
+	if err := json.Unmarshal(content, &lockfile); err != nil {
+		return nil, err
+	}
+
+	var items []*PrefetchItem
+	for _, key := range sortedKeys(lockfile.Packages) {
+		pkg := lockfile.Packages[key]
+		if !isHttpUrl(pkg.Resolved) {
+			// the root package, links and git dependencies
+			continue
+		}
+		// keys are paths like `node_modules/a/node_modules/@scope/b`
+		name := pkg.Name
+		if i := strings.LastIndex(key, "node_modules/"); i >= 0 {
+			name = key[i+len("node_modules/"):]
+		}
+		if name == "" {
+			continue
+		}
+		items = append(items, &PrefetchItem{
+			Name: name + "@" + pkg.Version,
+			Url:  pkg.Resolved,
+			Hash: pkg.Integrity,
+		})
+	}
+
+	// version 2 has both; version 1 only the nested `dependencies`
+	if len(lockfile.Packages) == 0 {
+		items = append(items, npmDependencyItems(lockfile.Dependencies)...)
+	}
+	return items, nil
+}
+
+func npmDependencyItems(dependencies map[string]npmDependency) []*PrefetchItem {
+	var items []*PrefetchItem
+	for _, name := range sortedKeys(dependencies) {
+		dependency := dependencies[name]
+		if isHttpUrl(dependency.Resolved) {
+			items = append(items, &PrefetchItem{
+				Name: name + "@" + dependency.Version,
+				Url:  dependency.Resolved,
+				Hash: dependency.Integrity,
+			})
+		}
+		items = append(items, npmDependencyItems(dependency.Dependencies)...)
+	}
+	return items
+}
+
+func isHttpUrl(url string) bool {
+	return strings.HasPrefix(url, "https://") || strings.HasPrefix(url, "http://")
+}
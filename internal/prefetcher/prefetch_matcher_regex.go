@@ -1,7 +1,6 @@
 package prefetcher
 
 import (
-	"fmt"
 	"log"
 	"os"
 	"regexp"
@@ -16,30 +15,45 @@ type PrefetchMatcherRegex struct {
 	format string
 }
 
-func (m *PrefetchMatcherRegex) Match() (bool, string, error) {
-	l := common.NewLoggerWithPrefixAndColor("PrefetchMatcherRegex: ")
-	l.Printf("Analyzing file: %s", m.file)
+func (m *PrefetchMatcherRegex) Match(logger *log.Logger, vars Vars) ([]*MatchResult, error) {
+	file := vars.expand(m.file)
+	regex := vars.expandRegex(m.regex)
+	l := common.NewLoggerWithPrefixAndColorTo(logger, "PrefetchMatcherRegex: ")
+	l.Printf("Analyzing file: %s", file)
 
-	pattern, err := regexp.Compile(m.regex)
+	pattern, err := regexp.Compile(regex)
 	if err != nil {
-		return false, "", err
+		return nil, err
 	}
 
-	content, err := os.ReadFile(m.file)
+	content, err := os.ReadFile(file)
 	if err != nil {
-		log.Printf("Failed to read file %s: %v", m.file, err)
-		return false, "", err
+		l.Printf("Failed to read file %s: %v", file, err)
+		return nil, err
 	}
 
+	scopes := newFileScopes(file, string(content))
+	results := make([]*MatchResult, 0)
+	offset := 0
 	lines := strings.Split(string(content), "\n")
-	for _, line := range lines {
-		matches := pattern.FindStringSubmatch(line)
-		if len(matches) >= 2 {
-			result := fmt.Sprintf(m.format, matches[1])
-			return true, result, nil
+	for i, line := range lines {
+		for _, matches := range pattern.FindAllStringSubmatchIndex(line, -1) {
+			groups := submatches(line, matches)
+			result := formatMatch(m.format, groups, pattern.SubexpNames(), vars)
+			results = append(results, &MatchResult{
+				Value:  result,
+				File:   file,
+				Line:   i + 1,
+				Scopes: scopes.at(offset + valueOffset(matches)),
+			})
 		}
+		offset += len(line) + 1
 	}
 
-	l.Printf("No match found for item %s in file %s", m.regex, m.file)
-	return false, "", nil
+	if len(results) == 0 {
+		l.Printf("No match found for item %s in file %s", regex, file)
+	} else {
+		l.Printf("Found %d matches in file %s", len(results), file)
+	}
+	return results, nil
 }
@@ -0,0 +1,212 @@
+package prefetcher
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"internal/common"
+	"log"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+)
+
+const (
+	RequirementsLockFileName = "requirements_lock.txt"
+	DefaultPythonIndex       = "https://pypi.org/simple"
+)
+
+var (
+	// `name[extras]==version`, the rest of the line being hashes and markers
+	requirementRegex = regexp.MustCompile(`^([A-Za-z0-9][A-Za-z0-9._-]*)(\[[^\]]*\])?\s*==\s*([^\s;\\]+)`)
+	pipHashRegex     = regexp.MustCompile(`--hash[=\s]+(\w+):([0-9a-fA-F]+)`)
+	pipIndexRegex    = regexp.MustCompile(`^(?:--index-url|-i)[=\s]+(\S+)`)
+	pythonNameRegex  = regexp.MustCompile(`[-_.]+`)
+	simpleIndexLink  = regexp.MustCompile(`<a\s[^>]*href\s*=\s*["']([^"']+)["']`)
+)
+
+// PrefetchMatcherRequirementsLock reads a hash-pinned requirements lock file, as
+// used by rules_python's pip.parse, and returns an item for every wheel and sdist
+// whose hash is pinned in it.
+//
+// The lock file has hashes but no URLs, so the URLs are looked up in a simple
+// repository index (PEP 503 HTML or PEP 691 JSON): index if set, otherwise the
+// `--index-url` of the file, otherwise pypi.org.
+type PrefetchMatcherRequirementsLock struct {
+	file       string
+	index      string
+	downloader Downloader
+}
+
+type pythonRequirement struct {
+	name    string
+	version string
+	hashes  map[string]bool // `<algorithm>:<hex>`
+}
+
+// simpleIndexFile is a file of a project page of a simple repository index
+type simpleIndexFile struct {
+	Url    string            `json:"url"`
+	Hashes map[string]string `json:"hashes"`
+}
+
+func (m *PrefetchMatcherRequirementsLock) Match(logger *log.Logger, vars Vars) ([]*MatchResult, error) {
+	items, err := m.MatchItems(logger, vars)
+	if err != nil {
+		return nil, err
+	}
+	return urlsOf(items), nil
+}
+
+func (m *PrefetchMatcherRequirementsLock) MatchItems(logger *log.Logger, vars Vars) ([]*PrefetchItem, error) {
+	file := vars.expand(m.file)
+	l := common.NewLoggerWithPrefixAndColorTo(logger, "PrefetchMatcherRequirementsLock: ")
+	l.Printf("Analyzing file: %s", file)
+
+	content, err := os.ReadFile(file)
+	if err != nil {
+		l.Printf("Failed to read file %s: %v", file, err)
+		return nil, err
+	}
+
+	requirements, fileIndex := parseRequirementsLock(string(content))
+	index := m.index
+	if index == "" {
+		index = fileIndex
+	}
+	if index == "" {
+		index = DefaultPythonIndex
+	}
+	index = strings.TrimSuffix(index, "/")
+	l.Printf("Found %d pinned requirements in %s, looking them up in %s", len(requirements), file, index)
+
+	items := make([]*PrefetchItem, 0)
+	for _, requirement := range requirements {
+		if len(requirement.hashes) == 0 {
+			l.Printf("Requirement %s==%s has no hashes, skipping.", requirement.name, requirement.version)
+			continue
+		}
+
+		files, err := simpleIndexFiles(m.downloader, index, requirement.name)
+		if err != nil {
+			l.Printf("Failed to look up %s in %s: %v", requirement.name, index, err)
+			return nil, fmt.Errorf("failed to look up %s in %s: %w", requirement.name, index, err)
+		}
+
+		found := 0
+		for _, f := range files {
+			for algorithm, hash := range f.Hashes {
+				checksum := algorithm + ":" + strings.ToLower(hash)
+				if !requirement.hashes[checksum] {
+					continue
+				}
+				items = append(items, &PrefetchItem{
+					Name: requirement.name + "==" + requirement.version,
+					Url:  f.Url,
+					Hash: checksum,
+				})
+				found++
+				break
+			}
+		}
+		if found == 0 {
+			l.Printf("No file of %s==%s in %s has a pinned hash.", requirement.name, requirement.version, index)
+		}
+	}
+
+	l.Printf("Found %d files in %s", len(items), file)
+	return items, nil
+}
+
+// parseRequirementsLock returns the pinned requirements of a lock file, and its `--index-url`.
+func parseRequirementsLock(content string) ([]*pythonRequirement, string) {
+	var requirements []*pythonRequirement
+	index := ""
+
+	// requirements continue on the next line after a trailing `\`
+	content = strings.ReplaceAll(content, "\\\r\n", " ")
+	content = strings.ReplaceAll(content, "\\\n", " ")
+	for _, line := range strings.Split(content, "\n") {
+		if i := strings.Index(line, " #"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if match := pipIndexRegex.FindStringSubmatch(line); match != nil {
+			index = match[1]
+			continue
+		}
+		match := requirementRegex.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+
+		requirement := &pythonRequirement{
+			name:    normalizePythonName(match[1]),
+			version: match[3],
+			hashes:  make(map[string]bool),
+		}
+		for _, hash := range pipHashRegex.FindAllStringSubmatch(line, -1) {
+			requirement.hashes[strings.ToLower(hash[1])+":"+strings.ToLower(hash[2])] = true
+		}
+		requirements = append(requirements, requirement)
+	}
+	return requirements, index
+}
+
+// normalizePythonName normalizes a project name like PEP 503 does for index URLs.
+func normalizePythonName(name string) string {
+	return pythonNameRegex.ReplaceAllString(strings.ToLower(name), "-")
+}
+
+// simpleIndexFiles returns the files of a project in a simple repository index,
+// with absolute URLs. The page can be PEP 691 JSON or PEP 503 HTML.
+func simpleIndexFiles(downloader Downloader, index string, name string) ([]*simpleIndexFile, error) {
+	pageUrl := index + "/" + name + "/"
+	base, err := url.Parse(pageUrl)
+	if err != nil {
+		return nil, err
+	}
+	content, err := downloadContent(downloader, pageUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []*simpleIndexFile
+	if json.Valid(content) {
+		var page struct {
+			Files []*simpleIndexFile `json:"files"`
+		}
+		if err := json.Unmarshal(content, &page); err != nil {
+			return nil, err
+		}
+		files = page.Files
+	} else {
+		// PEP 503: the hash is the fragment of the link, `#<algorithm>=<hex>`
+		for _, match := range simpleIndexLink.FindAllStringSubmatch(string(content), -1) {
+			href, fragment, _ := strings.Cut(html.UnescapeString(match[1]), "#")
+			f := &simpleIndexFile{Url: href, Hashes: make(map[string]string)}
+			if algorithm, hash, found := strings.Cut(fragment, "="); found {
+				f.Hashes[algorithm] = hash
+			}
+			files = append(files, f)
+		}
+	}
+
+	// URLs can be relative to the page
+	result := make([]*simpleIndexFile, 0, len(files))
+	for _, f := range files {
+		u, err := base.Parse(f.Url)
+		if err != nil {
+			continue
+		}
+		u.Fragment = ""
+		f.Url = u.String()
+		result = append(result, f)
+	}
+	return result, nil
+}
@@ -0,0 +1,137 @@
+package prefetcher
+
+import (
+	"fmt"
+	"internal/common"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+
+	"go.starlark.net/syntax"
+)
+
+const ResolvedFileName = "resolved.bzl"
+
+// PrefetchMatcherResolvedFile reads the file written by bazel's
+// `--experimental_repository_resolved_file`, which lists every repository
+// bazel fetched with its URLs and hashes, and returns an item for each of them.
+//
+// If cmd is set, it is run in srcDir first to (re)generate the file, e.g.
+// `bazel sync --experimental_repository_resolved_file=$out`. `$out` is
+// replaced with the path of the file.
+type PrefetchMatcherResolvedFile struct {
+	srcDir string
+	file   string
+	cmd    []string
+}
+
+func (m *PrefetchMatcherResolvedFile) Match(logger *log.Logger, vars Vars) ([]*MatchResult, error) {
+	items, err := m.MatchItems(logger, vars)
+	if err != nil {
+		return nil, err
+	}
+	return urlsOf(items), nil
+}
+
+func (m *PrefetchMatcherResolvedFile) MatchItems(logger *log.Logger, vars Vars) ([]*PrefetchItem, error) {
+	file := vars.expand(m.file)
+	l := common.NewLoggerWithPrefixAndColorTo(logger, "PrefetchMatcherResolvedFile: ")
+
+	if len(m.cmd) > 0 {
+		if err := m.generate(logger, file, vars); err != nil {
+			l.Printf("Failed to generate file %s: %v", file, err)
+			return nil, err
+		}
+	}
+
+	l.Printf("Analyzing file: %s", file)
+	f, err := parseStarlarkFile(file)
+	if err != nil {
+		l.Printf("Failed to parse file %s: %v", file, err)
+		return nil, err
+	}
+
+	var resolved []interface{}
+	env := starlarkEnv{}
+	for _, stmt := range f.Stmts {
+		assign, ok := stmt.(*syntax.AssignStmt)
+		if !ok {
+			continue
+		}
+		ident, ok := assign.LHS.(*syntax.Ident)
+		if !ok || ident.Name != "resolved" {
+			continue
+		}
+		v, ok := evalStarlarkExpr(assign.RHS, env)
+		if !ok {
+			return nil, fmt.Errorf("cannot evaluate `resolved` in %s", file)
+		}
+		if resolved, ok = v.([]interface{}); !ok {
+			return nil, fmt.Errorf("`resolved` in %s is not a list", file)
+		}
+	}
+
+	items := make([]*PrefetchItem, 0)
+	seen := make(map[string]bool)
+	for _, entry := range resolved {
+		entry, ok := entry.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		// `repositories` has the attributes after fetching, with the hashes
+		// bazel computed; `original_attributes` is what the WORKSPACE said.
+		var attributes []map[string]interface{}
+		if repositories, ok := entry["repositories"].([]interface{}); ok {
+			for _, repository := range repositories {
+				if repository, ok := repository.(map[string]interface{}); ok {
+					if a, ok := repository["attributes"].(map[string]interface{}); ok {
+						attributes = append(attributes, a)
+					}
+				}
+			}
+		}
+		if len(attributes) == 0 {
+			if a, ok := entry["original_attributes"].(map[string]interface{}); ok {
+				attributes = append(attributes, a)
+			}
+		}
+
+		for _, a := range attributes {
+			for _, item := range attributesToItems(a, "") {
+				if seen[item.Url] {
+					continue
+				}
+				seen[item.Url] = true
+				item.Source = file
+				items = append(items, item)
+			}
+		}
+	}
+
+	l.Printf("Found %d items in %s", len(items), file)
+	return items, nil
+}
+
+func (m *PrefetchMatcherResolvedFile) generate(logger *log.Logger, file string, vars Vars) error {
+	if logger == nil {
+		logger = log.Default()
+	}
+	l := common.NewLoggerWithPrefixAndColorTo(logger, "PrefetchMatcherResolvedFile: ")
+
+	args := make([]string, 0, len(m.cmd)-1)
+	for _, arg := range m.cmd[1:] {
+		args = append(args, strings.ReplaceAll(vars.expand(arg), "$out", file))
+	}
+
+	l.Printf("Run command: %s, %v", m.cmd[0], args)
+	if err := os.MkdirAll(path.Dir(file), 0755); err != nil {
+		return err
+	}
+	// the output goes to the log, as the stdout of analyze can be its JSON result
+	return common.RunCmdWithLogger(logger, m.srcDir, 0, m.cmd[0], args, func(stdout io.ReadCloser) {
+		io.Copy(logger.Writer(), stdout)
+	})
+}
@@ -0,0 +1,140 @@
+package prefetcher
+
+import (
+	"fmt"
+	"internal/common"
+	"log"
+
+	"go.starlark.net/syntax"
+)
+
+// httpRules are the repository rules from @bazel_tools//tools/build_defs/repo:http.bzl
+// that download a single file we can prefetch.
+var httpRules = map[string]bool{
+	"http_archive": true,
+	"http_file":    true,
+	"http_jar":     true,
+}
+
+// PrefetchMatcherStarlark parses a WORKSPACE, WORKSPACE.bzlmod or .bzl file
+// and returns one item for every http_archive, http_file and http_jar rule in it.
+type PrefetchMatcherStarlark struct {
+	file string
+}
+
+func (m *PrefetchMatcherStarlark) Match(logger *log.Logger, vars Vars) ([]*MatchResult, error) {
+	items, err := m.MatchItems(logger, vars)
+	if err != nil {
+		return nil, err
+	}
+	return urlsOf(items), nil
+}
+
+func (m *PrefetchMatcherStarlark) MatchItems(logger *log.Logger, vars Vars) ([]*PrefetchItem, error) {
+	file := vars.expand(m.file)
+	l := common.NewLoggerWithPrefixAndColorTo(logger, "PrefetchMatcherStarlark: ")
+	l.Printf("Analyzing file: %s", file)
+
+	f, err := parseStarlarkFile(file)
+	if err != nil {
+		l.Printf("Failed to parse file %s: %v", file, err)
+		return nil, err
+	}
+
+	// rules may be loaded under another name, e.g. load(..., my_archive = "http_archive")
+	rules := make(map[string]bool, len(httpRules))
+	for name := range httpRules {
+		rules[name] = true
+	}
+	for _, stmt := range f.Stmts {
+		if load, ok := stmt.(*syntax.LoadStmt); ok {
+			for i, from := range load.From {
+				if httpRules[from.Name] {
+					rules[load.To[i].Name] = true
+				}
+			}
+		}
+	}
+
+	var items []*PrefetchItem
+	walkStarlarkCalls(f.Stmts, starlarkEnv{}, func(call *syntax.CallExpr, env starlarkEnv) {
+		fn, ok := call.Fn.(*syntax.Ident)
+		if !ok {
+			return
+		}
+		positional, keywords := starlarkCallArgs(call)
+		if fn.Name == "maybe" && len(positional) > 0 {
+			// maybe(http_archive, name = ..., ...)
+			if rule, ok := positional[0].(*syntax.Ident); ok {
+				fn = rule
+			}
+		}
+		if !rules[fn.Name] {
+			return
+		}
+
+		item := httpRuleToItem(keywords, env)
+		if item == nil {
+			l.Printf("Skipping %s at %s: cannot evaluate its url", fn.Name, call.Lparen)
+			return
+		}
+		item.Source = fmt.Sprintf("%s:%d", file, call.Lparen.Line)
+		l.Printf("Found %s `%s` at %s: %s", fn.Name, item.Name, call.Lparen, item.Url)
+		items = append(items, item)
+	})
+
+	if len(items) == 0 {
+		l.Printf("No http rules found in file %s", file)
+	}
+	return items, nil
+}
+
+// httpRuleToItem builds an item from the attributes of an http_archive-like rule.
+// It returns nil if no URL can be determined without running Starlark code.
+func httpRuleToItem(keywords map[string]syntax.Expr, env starlarkEnv) *PrefetchItem {
+	attr := func(name string) (string, bool) {
+		expr, ok := keywords[name]
+		if !ok {
+			return "", false
+		}
+		v, ok := evalStarlarkExpr(expr, env)
+		if !ok {
+			return "", false
+		}
+		s, ok := v.(string)
+		return s, ok
+	}
+
+	// same order as http.bzl: `url` goes before `urls`
+	var urls []string
+	if url, ok := attr("url"); ok && url != "" {
+		urls = append(urls, url)
+	}
+	if expr, ok := keywords["urls"]; ok {
+		if v, ok := evalStarlarkExpr(expr, env); ok {
+			if list, ok := starlarkStringList(v); ok {
+				urls = append(urls, list...)
+			}
+		}
+	}
+	if len(urls) == 0 {
+		return nil
+	}
+
+	name, _ := attr("name")
+	hash, _ := attr("sha256")
+	if hash == "" {
+		// a subresource integrity value, e.g. `sha384-<base64>`
+		hash, _ = attr("integrity")
+	}
+
+	canonicalId, _ := attr("canonical_id")
+
+	return &PrefetchItem{
+		Name:        name,
+		Url:         urls[0],
+		Urls:        urls,
+		CanonicalId: canonicalId,
+		Hash:        hash,
+	}
+}
@@ -0,0 +1,100 @@
+package prefetcher
+
+import (
+	"encoding/json"
+	"fmt"
+	"internal/common"
+	"log"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// PrefetchMatcherStructured returns the values found by a path expression, see
+// parseDataPath, in a JSON, YAML or TOML file, which is told by its extension.
+// A path to a list returns each of its values.
+//
+// The value is formatted like a regex match whose first group is the value.
+// Values are scoped by the paths enclosing them, so a URL at `$.tools[2].url`
+// is paired with the hash at `$.tools[2].sha256`.
+type PrefetchMatcherStructured struct {
+	file   string
+	path   string
+	format string
+}
+
+func (m *PrefetchMatcherStructured) Match(logger *log.Logger, vars Vars) ([]*MatchResult, error) {
+	file := vars.expand(m.file)
+	expr := vars.expand(m.path)
+	l := common.NewLoggerWithPrefixAndColorTo(logger, "PrefetchMatcherStructured: ")
+	l.Printf("Analyzing file: %s", file)
+
+	steps, err := parseDataPath(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := readStructuredFile(file)
+	if err != nil {
+		l.Printf("Failed to read file %s: %v", file, err)
+		return nil, err
+	}
+
+	results := make([]*MatchResult, 0)
+	add := func(node dataNode) {
+		value := dataString(node.value)
+		if value == "" {
+			return
+		}
+		results = append(results, &MatchResult{
+			Value:  formatMatch(m.format, []string{value, value}, nil, vars),
+			File:   file,
+			Scopes: dataPathScopes(file, node.path),
+		})
+	}
+	for _, node := range evalDataPath(data, steps) {
+		if list, ok := node.value.([]interface{}); ok {
+			for i, value := range list {
+				add(dataNode{value: value, path: fmt.Sprintf("%s[%d]", node.path, i)})
+			}
+			continue
+		}
+		add(node)
+	}
+
+	if len(results) == 0 {
+		l.Printf("No value found at %s in file %s", expr, file)
+	} else {
+		l.Printf("Found %d values at %s in file %s", len(results), expr, file)
+	}
+	return results, nil
+}
+
+// readStructuredFile decodes a JSON, YAML or TOML file to maps, lists and scalars.
+func readStructuredFile(file string) (interface{}, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var data interface{}
+	switch strings.ToLower(path.Ext(file)) {
+	case ".json":
+		err = json.Unmarshal(content, &data)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, &data)
+	case ".toml":
+		var table map[string]interface{}
+		err = toml.Unmarshal(content, &table)
+		data = table
+	default:
+		return nil, fmt.Errorf("unsupported file type of %s, expected .json, .yaml, .yml or .toml", file)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return normalizeData(data), nil
+}
@@ -0,0 +1,146 @@
+package prefetcher
+
+import (
+	"fmt"
+	"sort"
+)
+
+// bracketSpan is a balanced pair of (), [] or {} in a file, given by the
+// offsets of the opening and the closing bracket.
+type bracketSpan struct {
+	start int
+	end   int
+}
+
+// fileScopes knows the brackets of one file, and tells which of them enclose an offset.
+type fileScopes struct {
+	file  string
+	spans []bracketSpan // sorted by start
+}
+
+func newFileScopes(file string, content string) *fileScopes {
+	spans := findBracketSpans(content)
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+	return &fileScopes{file: file, spans: spans}
+}
+
+// at returns the scopes enclosing offset, innermost first.
+func (s *fileScopes) at(offset int) []string {
+	var scopes []string
+	for _, span := range s.spans {
+		if span.start > offset {
+			break
+		}
+		if offset < span.end {
+			scopes = append(scopes, fmt.Sprintf("%s:%d-%d", s.file, span.start, span.end))
+		}
+	}
+	// spans are sorted by start, so the outermost one came first
+	for i, j := 0, len(scopes)-1; i < j; i, j = i+1, j-1 {
+		scopes[i], scopes[j] = scopes[j], scopes[i]
+	}
+	return scopes
+}
+
+// findBracketSpans returns every balanced bracket pair in content.
+// Brackets in quoted strings and after `#` comments are ignored.
+// Strings end at a newline unless they are triple quoted.
+func findBracketSpans(content string) []bracketSpan {
+	closing := map[byte]byte{'(': ')', '[': ']', '{': '}'}
+
+	var spans []bracketSpan
+	var stack []int
+	var quote string
+	for i := 0; i < len(content); i++ {
+		c := content[i]
+
+		if quote != "" {
+			switch {
+			case c == '\\':
+				i++
+			case c == '\n' && len(quote) == 1:
+				quote = ""
+			case c == quote[0] && hasPrefixAt(content, i, quote):
+				i += len(quote) - 1
+				quote = ""
+			}
+			continue
+		}
+
+		switch c {
+		case '"', '\'':
+			quote = string(c)
+			if hasPrefixAt(content, i, quote+quote+quote) {
+				quote = quote + quote + quote
+				i += 2
+			}
+		case '#':
+			for i < len(content) && content[i] != '\n' {
+				i++
+			}
+		case '(', '[', '{':
+			stack = append(stack, i)
+		case ')', ']', '}':
+			// drop unbalanced openers until the matching one
+			for len(stack) > 0 {
+				start := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				if closing[content[start]] == c {
+					spans = append(spans, bracketSpan{start: start, end: i})
+					break
+				}
+			}
+		}
+	}
+	return spans
+}
+
+func hasPrefixAt(s string, i int, prefix string) bool {
+	return len(s)-i >= len(prefix) && s[i:i+len(prefix)] == prefix
+}
+
+// pairUrlsAndHashes finds the hash of every URL.
+//
+// A hash belongs to a URL when it is the only hash inside the innermost
+// brackets around the URL which contain any hash at all, e.g. the same
+// http_archive(...) call, or the same {...} in a dict of versions. If
+// the hash matcher found a single value outside of any file, e.g. a
+// hardcoded one, it belongs to every URL. Only if no hash was found at all,
+// URLs get an empty hash. Other URLs, whose hash cannot be told, e.g. when
+// the hashes are in another dict or file, are paired with nil.
+func pairUrlsAndHashes(urls []*MatchResult, hashes []*MatchResult) []*MatchResult {
+	result := make([]*MatchResult, len(urls))
+	if len(hashes) == 0 {
+		for i := range urls {
+			result[i] = &MatchResult{Value: ""}
+		}
+		return result
+	}
+	if len(hashes) == 1 && len(hashes[0].Scopes) == 0 {
+		for i := range urls {
+			result[i] = hashes[0]
+		}
+		return result
+	}
+
+	hashesInScope := make(map[string][]*MatchResult)
+	for _, hash := range hashes {
+		for _, scope := range hash.Scopes {
+			hashesInScope[scope] = append(hashesInScope[scope], hash)
+		}
+	}
+
+	for i, url := range urls {
+		for _, scope := range url.Scopes {
+			candidates := hashesInScope[scope]
+			if len(candidates) == 0 {
+				continue
+			}
+			if len(candidates) == 1 {
+				result[i] = candidates[0]
+			}
+			break
+		}
+	}
+	return result
+}
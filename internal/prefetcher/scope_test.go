@@ -0,0 +1,146 @@
+package prefetcher
+
+import (
+	"regexp"
+	"testing"
+)
+
+// matchesIn returns the first group of every match of regex in content, with its scopes.
+func matchesIn(content string, regex string) []*MatchResult {
+	scopes := newFileScopes("BUILD", content)
+	var results []*MatchResult
+	for _, m := range regexp.MustCompile(regex).FindAllStringSubmatchIndex(content, -1) {
+		results = append(results, &MatchResult{
+			Value:  content[m[2]:m[3]],
+			File:   "BUILD",
+			Scopes: scopes.at(m[2]),
+		})
+	}
+	return results
+}
+
+func TestPairUrlsAndHashes(t *testing.T) {
+	const urlRegex = `url = "([^"]+)"`
+	const hashRegex = `sha256 = "([^"]+)"`
+	tests := []struct {
+		name    string
+		content string
+		hashes  []*MatchResult // found in content with hashRegex if nil
+		want    []string       // hash of every URL, `<nil>` if it cannot be told
+	}{
+		{
+			name: "same call",
+			content: `
+http_archive(
+    name = "a",
+    url = "https://a.com/a.tar.gz",
+    sha256 = "aaa",
+)
+http_archive(
+    name = "b",
+    sha256 = "bbb",
+    url = "https://b.com/b.tar.gz",
+)`,
+			want: []string{"aaa", "bbb"},
+		},
+		{
+			name: "dict of versions",
+			content: `
+TOOLS = {
+    "linux": {
+        url = "https://a.com/linux.tar.gz",
+        sha256 = "111",
+    },
+    "darwin": {
+        url = "https://a.com/darwin.tar.gz",
+        sha256 = "222",
+    },
+}`,
+			want: []string{"111", "222"},
+		},
+		{
+			name: "block without hash",
+			content: `
+http_archive(
+    name = "a",
+    url = "https://a.com/a.tar.gz",
+    sha256 = "aaa",
+)
+http_archive(
+    name = "b",
+    url = "https://b.com/b.tar.gz",
+)`,
+			want: []string{"aaa", "<nil>"},
+		},
+		{
+			name: "separate dicts of urls and hashes",
+			content: `
+URLS = {
+    "linux": {url = "https://a.com/linux.tar.gz"},
+    "darwin": {url = "https://a.com/darwin.tar.gz"},
+}
+SHAS = {
+    "linux": {sha256 = "111"},
+    "darwin": {sha256 = "222"},
+}`,
+			want: []string{"<nil>", "<nil>"},
+		},
+		{
+			name: "no hash at all",
+			content: `
+http_archive(
+    name = "a",
+    url = "https://a.com/a.tar.gz",
+)`,
+			hashes: []*MatchResult{},
+			want:   []string{""},
+		},
+		{
+			name: "single hardcoded hash",
+			content: `
+http_archive(
+    name = "a",
+    url = "https://a.com/a.tar.gz",
+)
+http_archive(
+    name = "b",
+    url = "https://b.com/b.tar.gz",
+)`,
+			hashes: []*MatchResult{{Value: "hardcoded"}},
+			want:   []string{"hardcoded", "hardcoded"},
+		},
+		{
+			name: "two hashes in one call",
+			content: `
+http_archive(
+    url = "https://a.com/a.tar.gz",
+    sha256 = "aaa",
+    sha256 = "bbb",
+)`,
+			want: []string{"<nil>"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			urls := matchesIn(tt.content, urlRegex)
+			hashes := tt.hashes
+			if hashes == nil {
+				hashes = matchesIn(tt.content, hashRegex)
+			}
+			pairs := pairUrlsAndHashes(urls, hashes)
+			if len(pairs) != len(tt.want) {
+				t.Fatalf("got %d pairs, want %d", len(pairs), len(tt.want))
+			}
+			for i, want := range tt.want {
+				got := "<nil>"
+				if pairs[i] != nil {
+					got = pairs[i].Value
+				}
+				if got != want {
+					t.Errorf("hash of %s = %q, want %q", urls[i].Value, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,458 @@
+package prefetcher
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"go.starlark.net/syntax"
+)
+
+// starlarkEnv holds the values of the names assigned so far, as far as they
+// can be evaluated without running the file.
+type starlarkEnv map[string]interface{}
+
+func (e starlarkEnv) clone() starlarkEnv {
+	result := make(starlarkEnv, len(e))
+	for k, v := range e {
+		result[k] = v
+	}
+	return result
+}
+
+// parseStarlarkFile reads and parses a WORKSPACE, BUILD or .bzl file.
+func parseStarlarkFile(file string) (*syntax.File, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := &syntax.FileOptions{
+		Set:             true,
+		While:           true,
+		TopLevelControl: true,
+		GlobalReassign:  true,
+		Recursion:       true,
+	}
+	return opts.Parse(file, content, 0)
+}
+
+// walkStarlarkCalls visits every call expression in stmts, together with the
+// names visible at that point. Top level assignments are visible everywhere after
+// they are made, assignments inside a function only inside that function.
+func walkStarlarkCalls(stmts []syntax.Stmt, env starlarkEnv, visit func(call *syntax.CallExpr, env starlarkEnv)) {
+	for _, stmt := range stmts {
+		switch s := stmt.(type) {
+		case *syntax.AssignStmt:
+			walkStarlarkExprCalls(s.RHS, env, visit)
+			var v interface{}
+			ok := false
+			switch s.Op {
+			case syntax.EQ:
+				v, ok = evalStarlarkExpr(s.RHS, env)
+			case syntax.PLUS_EQ:
+				// `x += y` is `x = x + y`
+				v, ok = evalStarlarkExpr(&syntax.BinaryExpr{X: s.LHS, Op: syntax.PLUS, Y: s.RHS}, env)
+			}
+			assignStarlarkTarget(env, s.LHS, v, ok)
+		case *syntax.ExprStmt:
+			walkStarlarkExprCalls(s.X, env, visit)
+		case *syntax.DefStmt:
+			// parameters shadow the globals of the same name, and have no known value
+			defEnv := env.clone()
+			for _, param := range s.Params {
+				if name := starlarkParamName(param); name != "" {
+					delete(defEnv, name)
+				}
+			}
+			walkStarlarkCalls(s.Body, defEnv, visit)
+		case *syntax.IfStmt:
+			walkStarlarkCalls(s.True, env, visit)
+			walkStarlarkCalls(s.False, env, visit)
+		case *syntax.ForStmt:
+			// loop variables shadow the globals of the same name, and have no single value
+			forEnv := env.clone()
+			assignStarlarkTarget(forEnv, s.Vars, nil, false)
+			walkStarlarkCalls(s.Body, forEnv, visit)
+		case *syntax.WhileStmt:
+			walkStarlarkCalls(s.Body, env, visit)
+		case *syntax.ReturnStmt:
+			if s.Result != nil {
+				walkStarlarkExprCalls(s.Result, env, visit)
+			}
+		}
+	}
+}
+
+// assignStarlarkTarget binds the names of an assignment target, e.g. `x` or
+// `x, y`, to value if ok, and removes them from env otherwise. An indexed or
+// dotted target, e.g. `x[0]`, removes the name it changes.
+func assignStarlarkTarget(env starlarkEnv, target syntax.Expr, value interface{}, ok bool) {
+	switch t := target.(type) {
+	case *syntax.Ident:
+		if ok {
+			env[t.Name] = value
+		} else {
+			delete(env, t.Name)
+		}
+	case *syntax.ParenExpr:
+		assignStarlarkTarget(env, t.X, value, ok)
+	case *syntax.TupleExpr:
+		assignStarlarkTargets(env, t.List, value, ok)
+	case *syntax.ListExpr:
+		assignStarlarkTargets(env, t.List, value, ok)
+	case *syntax.IndexExpr:
+		assignStarlarkTarget(env, t.X, nil, false)
+	case *syntax.DotExpr:
+		assignStarlarkTarget(env, t.X, nil, false)
+	}
+}
+
+func assignStarlarkTargets(env starlarkEnv, targets []syntax.Expr, value interface{}, ok bool) {
+	values, isList := value.([]interface{})
+	ok = ok && isList && len(values) == len(targets)
+	for i, target := range targets {
+		var v interface{}
+		if ok {
+			v = values[i]
+		}
+		assignStarlarkTarget(env, target, v, ok)
+	}
+}
+
+// starlarkParamName returns the name of a parameter of a def: `x`, `x = default`,
+// `*x` or `**x`, and "" for a bare `*`.
+func starlarkParamName(param syntax.Expr) string {
+	switch p := param.(type) {
+	case *syntax.Ident:
+		return p.Name
+	case *syntax.BinaryExpr:
+		if ident, ok := p.X.(*syntax.Ident); ok {
+			return ident.Name
+		}
+	case *syntax.UnaryExpr:
+		if ident, ok := p.X.(*syntax.Ident); ok {
+			return ident.Name
+		}
+	}
+	return ""
+}
+
+func walkStarlarkExprCalls(expr syntax.Expr, env starlarkEnv, visit func(call *syntax.CallExpr, env starlarkEnv)) {
+	syntax.Walk(expr, func(n syntax.Node) bool {
+		if call, ok := n.(*syntax.CallExpr); ok {
+			visit(call, env)
+		}
+		return true
+	})
+}
+
+// starlarkCallArgs splits the arguments of a call into positional and keyword arguments.
+func starlarkCallArgs(call *syntax.CallExpr) ([]syntax.Expr, map[string]syntax.Expr) {
+	var positional []syntax.Expr
+	keywords := make(map[string]syntax.Expr)
+	for _, arg := range call.Args {
+		if bin, ok := arg.(*syntax.BinaryExpr); ok && bin.Op == syntax.EQ {
+			if ident, ok := bin.X.(*syntax.Ident); ok {
+				keywords[ident.Name] = bin.Y
+				continue
+			}
+		}
+		positional = append(positional, arg)
+	}
+	return positional, keywords
+}
+
+// evalStarlarkExpr evaluates the constant parts of the Starlark language:
+// string, int, list, tuple and dict literals, names bound to those,
+// `+`, `%`, indexing and str.format(). The result is a string, int64,
+// []interface{} or map[string]interface{}. It returns false for anything else.
+func evalStarlarkExpr(expr syntax.Expr, env starlarkEnv) (interface{}, bool) {
+	switch e := expr.(type) {
+	case *syntax.Literal:
+		switch v := e.Value.(type) {
+		case string:
+			return v, true
+		case int64:
+			return v, true
+		}
+		return nil, false
+
+	case *syntax.Ident:
+		switch e.Name {
+		case "None":
+			return nil, false
+		case "True":
+			return int64(1), true
+		case "False":
+			return int64(0), true
+		}
+		v, ok := env[e.Name]
+		return v, ok
+
+	case *syntax.ParenExpr:
+		return evalStarlarkExpr(e.X, env)
+
+	case *syntax.ListExpr:
+		return evalStarlarkList(e.List, env)
+
+	case *syntax.TupleExpr:
+		return evalStarlarkList(e.List, env)
+
+	case *syntax.DictExpr:
+		result := make(map[string]interface{}, len(e.List))
+		for _, entry := range e.List {
+			entry, ok := entry.(*syntax.DictEntry)
+			if !ok {
+				return nil, false
+			}
+			key, ok := evalStarlarkExpr(entry.Key, env)
+			if !ok {
+				return nil, false
+			}
+			keyStr, ok := key.(string)
+			if !ok {
+				return nil, false
+			}
+			value, ok := evalStarlarkExpr(entry.Value, env)
+			if !ok {
+				continue
+			}
+			result[keyStr] = value
+		}
+		return result, true
+
+	case *syntax.BinaryExpr:
+		x, ok := evalStarlarkExpr(e.X, env)
+		if !ok {
+			return nil, false
+		}
+		y, ok := evalStarlarkExpr(e.Y, env)
+		if !ok {
+			return nil, false
+		}
+		switch e.Op {
+		case syntax.PLUS:
+			switch xv := x.(type) {
+			case string:
+				if yv, ok := y.(string); ok {
+					return xv + yv, true
+				}
+			case int64:
+				if yv, ok := y.(int64); ok {
+					return xv + yv, true
+				}
+			case []interface{}:
+				if yv, ok := y.([]interface{}); ok {
+					return append(append([]interface{}{}, xv...), yv...), true
+				}
+			}
+		case syntax.PERCENT:
+			if format, ok := x.(string); ok {
+				return starlarkPercentFormat(format, y)
+			}
+		}
+		return nil, false
+
+	case *syntax.IndexExpr:
+		x, ok := evalStarlarkExpr(e.X, env)
+		if !ok {
+			return nil, false
+		}
+		index, ok := evalStarlarkExpr(e.Y, env)
+		if !ok {
+			return nil, false
+		}
+		switch xv := x.(type) {
+		case map[string]interface{}:
+			if key, ok := index.(string); ok {
+				v, ok := xv[key]
+				return v, ok
+			}
+		case []interface{}:
+			if i, ok := index.(int64); ok {
+				if i < 0 {
+					i += int64(len(xv))
+				}
+				if i >= 0 && i < int64(len(xv)) {
+					return xv[i], true
+				}
+			}
+		}
+		return nil, false
+
+	case *syntax.CallExpr:
+		dot, ok := e.Fn.(*syntax.DotExpr)
+		if !ok {
+			return nil, false
+		}
+		receiver, ok := evalStarlarkExpr(dot.X, env)
+		if !ok {
+			return nil, false
+		}
+		str, ok := receiver.(string)
+		if !ok {
+			return nil, false
+		}
+		positional, keywords := starlarkCallArgs(e)
+		args := make([]interface{}, 0, len(positional))
+		for _, arg := range positional {
+			v, ok := evalStarlarkExpr(arg, env)
+			if !ok {
+				return nil, false
+			}
+			args = append(args, v)
+		}
+		switch dot.Name.Name {
+		case "format":
+			kwargs := make(map[string]interface{}, len(keywords))
+			for k, arg := range keywords {
+				v, ok := evalStarlarkExpr(arg, env)
+				if !ok {
+					return nil, false
+				}
+				kwargs[k] = v
+			}
+			return starlarkStrFormat(str, args, kwargs)
+		case "replace":
+			if len(args) == 2 {
+				from, ok1 := args[0].(string)
+				to, ok2 := args[1].(string)
+				if ok1 && ok2 {
+					return strings.ReplaceAll(str, from, to), true
+				}
+			}
+		case "lower":
+			return strings.ToLower(str), true
+		case "upper":
+			return strings.ToUpper(str), true
+		}
+		return nil, false
+	}
+
+	return nil, false
+}
+
+func evalStarlarkList(exprs []syntax.Expr, env starlarkEnv) (interface{}, bool) {
+	result := make([]interface{}, 0, len(exprs))
+	for _, x := range exprs {
+		v, ok := evalStarlarkExpr(x, env)
+		if !ok {
+			return nil, false
+		}
+		result = append(result, v)
+	}
+	return result, true
+}
+
+func starlarkStr(v interface{}) string {
+	switch x := v.(type) {
+	case string:
+		return x
+	case int64:
+		return strconv.FormatInt(x, 10)
+	}
+	return fmt.Sprint(v)
+}
+
+// starlarkPercentFormat implements `"..." % args` for %s and %d.
+func starlarkPercentFormat(format string, arg interface{}) (interface{}, bool) {
+	args, ok := arg.([]interface{})
+	if !ok {
+		args = []interface{}{arg}
+	}
+
+	var sb strings.Builder
+	next := 0
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+		if c != '%' {
+			sb.WriteByte(c)
+			continue
+		}
+		if i+1 >= len(format) {
+			return nil, false
+		}
+		i++
+		switch format[i] {
+		case '%':
+			sb.WriteByte('%')
+		case 's', 'd', 'r':
+			if next >= len(args) {
+				return nil, false
+			}
+			sb.WriteString(starlarkStr(args[next]))
+			next++
+		default:
+			return nil, false
+		}
+	}
+	return sb.String(), next == len(args)
+}
+
+// starlarkStrFormat implements str.format() for `{}`, `{0}` and `{name}` fields.
+func starlarkStrFormat(format string, args []interface{}, kwargs map[string]interface{}) (interface{}, bool) {
+	var sb strings.Builder
+	next := 0
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+		switch {
+		case c == '{' && i+1 < len(format) && format[i+1] == '{':
+			sb.WriteByte('{')
+			i++
+		case c == '}' && i+1 < len(format) && format[i+1] == '}':
+			sb.WriteByte('}')
+			i++
+		case c == '{':
+			end := strings.IndexByte(format[i:], '}')
+			if end < 0 {
+				return nil, false
+			}
+			field := format[i+1 : i+end]
+			i += end
+
+			var v interface{}
+			if field == "" {
+				if next >= len(args) {
+					return nil, false
+				}
+				v = args[next]
+				next++
+			} else if n, err := strconv.Atoi(field); err == nil {
+				if n < 0 || n >= len(args) {
+					return nil, false
+				}
+				v = args[n]
+			} else {
+				var ok bool
+				if v, ok = kwargs[field]; !ok {
+					return nil, false
+				}
+			}
+			sb.WriteString(starlarkStr(v))
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String(), true
+}
+
+// starlarkStringList converts the value of a `urls`-like attribute to a list of strings.
+func starlarkStringList(v interface{}) ([]string, bool) {
+	switch x := v.(type) {
+	case string:
+		return []string{x}, true
+	case []interface{}:
+		result := make([]string, 0, len(x))
+		for _, i := range x {
+			s, ok := i.(string)
+			if !ok {
+				return nil, false
+			}
+			result = append(result, s)
+		}
+		return result, true
+	}
+	return nil, false
+}
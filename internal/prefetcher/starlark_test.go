@@ -0,0 +1,132 @@
+package prefetcher
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPrefetchMatcherStarlark(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []string // `<name> <hash> <urls...>` of every item
+	}{
+		{
+			name: "url and urls",
+			content: `
+load("@bazel_tools//tools/build_defs/repo:http.bzl", "http_archive")
+
+http_archive(
+    name = "a",
+    url = "https://a.com/a.tar.gz",
+    urls = ["https://mirror.com/a.tar.gz"],
+    sha256 = "aaa",
+)`,
+			want: []string{"a aaa https://a.com/a.tar.gz https://mirror.com/a.tar.gz"},
+		},
+		{
+			name: "load alias and maybe",
+			content: `
+load("@bazel_tools//tools/build_defs/repo:http.bzl", my_archive = "http_archive", "http_file")
+load("@bazel_tools//tools/build_defs/repo:utils.bzl", "maybe")
+
+my_archive(name = "a", url = "https://a.com/a.tar.gz", sha256 = "aaa")
+maybe(http_file, name = "b", urls = ["https://b.com/b"], sha256 = "bbb")
+other_rule(name = "c", url = "https://c.com/c.tar.gz")`,
+			want: []string{
+				"a aaa https://a.com/a.tar.gz",
+				"b bbb https://b.com/b",
+			},
+		},
+		{
+			name: "percent and format",
+			content: `
+VERSION = "1.2.3"
+INFO = {"sha": "aaa", "version": VERSION}
+
+http_archive(
+    name = "a",
+    urls = [
+        "https://a.com/v%s/a-%s.tar.gz" % (VERSION, VERSION),
+        "https://mirror.com/{v}/a.tar.gz".format(v = INFO["version"]),
+    ],
+    sha256 = INFO["sha"],
+)`,
+			want: []string{"a aaa https://a.com/v1.2.3/a-1.2.3.tar.gz https://mirror.com/1.2.3/a.tar.gz"},
+		},
+		{
+			name: "augmented assignment",
+			content: `
+URLS = ["https://a.com/a.tar.gz"]
+URLS += ["https://mirror.com/a.tar.gz"]
+
+http_archive(name = "a", urls = URLS, sha256 = "aaa")`,
+			want: []string{"a aaa https://a.com/a.tar.gz https://mirror.com/a.tar.gz"},
+		},
+		{
+			name: "tuple assignment",
+			content: `
+version = "1.0"
+version, sha = "2.0", "bbb"
+http_archive(name = "a", url = "https://a.com/%s.tar.gz" % version, sha256 = sha)
+
+version, sha = get_version()
+http_archive(name = "b", url = "https://b.com/%s.tar.gz" % version, sha256 = sha)`,
+			want: []string{"a bbb https://a.com/2.0.tar.gz"},
+		},
+		{
+			name: "def parameters",
+			content: `
+version = "1.0"
+
+def repositories(version, sha256 = "aaa", *args, **kwargs):
+    http_archive(name = "a", url = "https://a.com/%s.tar.gz" % version)
+    http_archive(name = "b", url = "https://b.com/%s.tar.gz" % version, sha256 = sha256)`,
+			want: nil,
+		},
+		{
+			name: "for loop variables",
+			content: `
+version = "1.0"
+
+for version in ["2.0", "3.0"]:
+    http_archive(name = "a", url = "https://a.com/%s.tar.gz" % version)
+
+http_archive(name = "b", url = "https://b.com/%s.tar.gz" % version)`,
+			want: []string{"b  https://b.com/1.0.tar.gz"},
+		},
+		{
+			name: "indexed assignment",
+			content: `
+URLS = {"a": "https://a.com/a.tar.gz"}
+URLS["a"] = get_url()
+
+http_archive(name = "a", url = URLS["a"])`,
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "WORKSPACE")
+			if err := os.WriteFile(file, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			m := &PrefetchMatcherStarlark{file: file}
+			items, err := m.MatchItems(nil, Vars{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, item := range items {
+				got = append(got, strings.Join(append([]string{item.Name, item.Hash}, item.Urls...), " "))
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got items:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
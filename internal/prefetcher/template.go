@@ -0,0 +1,152 @@
+package prefetcher
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Formats, file names and regexes of matcher configs are templates. They reference
+// values as `{NAME}` (params and named capture groups) or `{1}` (numbered capture groups,
+// `{0}` being the whole match). A reference may pipe the value through helpers, which
+// take their arguments after colons:
+//
+//	https://example.com/{name}/{version|trim_prefix:v}/{1|basename}
+//	{ARCH|replace:amd64:x86_64|lower}
+var templateRefRegex = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*|[0-9]+)((?:\|[A-Za-z_]+(?::[^{}|:]*)*)*)\}`)
+
+var templateHelpers = map[string]func(value string, args []string) (string, bool){
+	"basename": func(value string, args []string) (string, bool) {
+		if len(args) != 0 {
+			return "", false
+		}
+		// drop the query of URLs
+		if i := strings.IndexAny(value, "?#"); i >= 0 {
+			value = value[:i]
+		}
+		return path.Base(value), true
+	},
+	"lower": func(value string, args []string) (string, bool) {
+		return strings.ToLower(value), len(args) == 0
+	},
+	"upper": func(value string, args []string) (string, bool) {
+		return strings.ToUpper(value), len(args) == 0
+	},
+	"replace": func(value string, args []string) (string, bool) {
+		if len(args) != 2 {
+			return "", false
+		}
+		return strings.ReplaceAll(value, args[0], args[1]), true
+	},
+	"trim_prefix": func(value string, args []string) (string, bool) {
+		if len(args) != 1 {
+			return "", false
+		}
+		return strings.TrimPrefix(value, args[0]), true
+	},
+	"trim_suffix": func(value string, args []string) (string, bool) {
+		if len(args) != 1 {
+			return "", false
+		}
+		return strings.TrimSuffix(value, args[0]), true
+	},
+}
+
+// expandTemplate replaces the references in s with their values, passed through
+// their helpers and then quote. References to unknown values or helpers, and
+// helpers with the wrong number of arguments, are left untouched.
+func expandTemplate(s string, values map[string]string, quote func(string) string) string {
+	if len(values) == 0 {
+		return s
+	}
+	return templateRefRegex.ReplaceAllStringFunc(s, func(ref string) string {
+		groups := templateRefRegex.FindStringSubmatch(ref)
+		value, ok := values[groups[1]]
+		if !ok {
+			return ref
+		}
+		if groups[2] != "" {
+			for _, call := range strings.Split(groups[2][1:], "|") {
+				parts := strings.Split(call, ":")
+				helper, ok := templateHelpers[parts[0]]
+				if !ok {
+					return ref
+				}
+				if value, ok = helper(value, parts[1:]); !ok {
+					return ref
+				}
+			}
+		}
+		return quote(value)
+	})
+}
+
+// checkTemplate returns an error for references in s to unknown helpers.
+func checkTemplate(s string) error {
+	for _, groups := range templateRefRegex.FindAllStringSubmatch(s, -1) {
+		if groups[2] == "" {
+			continue
+		}
+		for _, call := range strings.Split(groups[2][1:], "|") {
+			name := strings.Split(call, ":")[0]
+			if _, ok := templateHelpers[name]; !ok {
+				return fmt.Errorf("unknown helper `%s` in `%s`", name, groups[0])
+			}
+		}
+	}
+	return nil
+}
+
+// formatMatch builds the result of a regex match from the format of a matcher config.
+// groups are the submatches of the regex, and names its SubexpNames.
+//
+// A format with `%s` is the legacy format: `%s` is replaced with the first capture group.
+// Otherwise the format is a template, which can use the params of the item,
+// and the numbered and named groups of the match. An empty format returns the
+// first capture group, or the whole match if there is none.
+func formatMatch(format string, groups []string, names []string, vars Vars) string {
+	if format == "" {
+		if len(groups) > 1 {
+			return groups[1]
+		}
+		return groups[0]
+	}
+
+	if strings.Contains(format, "%s") && len(groups) > 1 {
+		format = fmt.Sprintf(format, groups[1])
+	}
+
+	values := make(map[string]string, len(vars)+2*len(groups))
+	for k, v := range vars {
+		values[k] = v
+	}
+	for i, group := range groups {
+		values[fmt.Sprint(i)] = group
+		if i < len(names) && names[i] != "" {
+			values[names[i]] = group
+		}
+	}
+	return expandTemplate(format, values, func(value string) string { return value })
+}
+
+// submatches returns the capture groups of a match from the indexes returned by
+// regexp.FindStringSubmatchIndex; unmatched groups are empty strings.
+func submatches(s string, indexes []int) []string {
+	groups := make([]string, len(indexes)/2)
+	for i := range groups {
+		if indexes[2*i] >= 0 {
+			groups[i] = s[indexes[2*i]:indexes[2*i+1]]
+		}
+	}
+	return groups
+}
+
+// valueOffset returns where the value of a match starts: the first capture group
+// if it matched, otherwise the whole match.
+func valueOffset(indexes []int) int {
+	if len(indexes) >= 4 && indexes[2] >= 0 {
+		return indexes[2]
+	}
+	return indexes[0]
+}
@@ -0,0 +1,31 @@
+package prefetcher
+
+import (
+	"regexp"
+)
+
+// Vars are the named values available to the matchers of an item, e.g. its params.
+// Matchers reference them as `{NAME}` in file names, regexes and formats.
+type Vars map[string]string
+
+// expand replaces the `{NAME}` template references in s with the value of
+// NAME. References to unknown names are left untouched, so regex repetitions
+// like `{2}` keep working.
+func (v Vars) expand(s string) string {
+	return expandTemplate(s, v, func(value string) string { return value })
+}
+
+// expandRegex is expand for regexes: the values are matched literally.
+func (v Vars) expandRegex(s string) string {
+	return expandTemplate(s, v, regexp.QuoteMeta)
+}
+
+// with returns a copy of v with name set to value.
+func (v Vars) with(name string, value string) Vars {
+	result := make(Vars, len(v)+1)
+	for k, x := range v {
+		result[k] = x
+	}
+	result[name] = value
+	return result
+}